@@ -5,8 +5,6 @@ import (
 	"time"
 
 	hera "github.com/elarianltd/go-sdk/com_elarian_hera_proto"
-	"github.com/golang/protobuf/proto"
-	"github.com/rsocket/rsocket-go/payload"
 )
 
 type (
@@ -21,18 +19,10 @@ func (s *elarian) GenerateAuthToken(ctx context.Context) (*GenerateAuthTokenRepl
 	req := &hera.AppToServerCommand{
 		Entry: &hera.AppToServerCommand_GenerateAuthToken{},
 	}
-	data, err := proto.Marshal(req)
+	reply, err := s.sendCommand(ctx, req)
 	if err != nil {
 		return nil, err
 	}
-	res, err := s.client.RequestResponse(payload.New(data, []byte{})).Block(ctx)
-	if err != nil {
-		return nil, err
-	}
-	reply := &hera.AppToServerCommandReply{}
-	if err := proto.Unmarshal(res.Data(), reply); err != nil {
-		return nil, err
-	}
 	return &GenerateAuthTokenReply{
 		LifeTime: reply.GetGenerateAuthToken().Lifetime.AsDuration(),
 		Token:    reply.GetGenerateAuthToken().Token,
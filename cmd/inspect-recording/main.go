@@ -0,0 +1,69 @@
+// Command inspect-recording prints the notifications recorded with Options.RecordPath, in either RecordFormat.
+//
+//	go run ./cmd/inspect-recording [-customer el_cst_...] [-entry customer.received_payment] [-frames] recording.jsonl
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	elarian "github.com/elarianltd/go-sdk"
+)
+
+func main() {
+	customerID := flag.String("customer", "", "only print notifications about this customer")
+	entry := flag.String("entry", "", "only print notifications whose entry starts with this prefix")
+	frames := flag.Bool("frames", false, "print the recorded frame of every notification")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] recording\n\nrecordings in the json and protobuf formats are detected\n\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	recorded, err := elarian.ReadRecording(flag.Arg(0))
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	out := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(out, "#\tRECEIVED AT\tENTRY\tCUSTOMER")
+	counts := make(map[string]int)
+	for i, notification := range recorded {
+		if *customerID != "" && notification.CustomerID != *customerID {
+			continue
+		}
+		if !strings.HasPrefix(notification.Entry, *entry) {
+			continue
+		}
+		name := notification.Entry
+		if notification.Simulator {
+			name = "simulator." + name
+		}
+		counts[name]++
+		fmt.Fprintf(out, "%d\t%s\t%s\t%s\n", i+1, notification.ReceivedAt.Format(time.RFC3339Nano), name, notification.CustomerID)
+		if *frames {
+			fmt.Fprintf(out, "\t%s\n", notification.Frame)
+		}
+	}
+	out.Flush()
+
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Println()
+	for _, name := range names {
+		fmt.Printf("%6d %s\n", counts[name], name)
+	}
+}
@@ -0,0 +1,415 @@
+package elarian
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/rsocket/rsocket-go"
+	"github.com/rsocket/rsocket-go/core"
+	"github.com/rsocket/rsocket-go/core/transport"
+	"github.com/rsocket/rsocket-go/payload"
+	"github.com/rsocket/rsocket-go/rx/mono"
+)
+
+type (
+	// ConnectionState describes the state of the connection to elarian
+	ConnectionState int32
+
+	// ConnectionStateHandler is called with the new state every time the connection state changes. It should not block.
+	ConnectionStateHandler func(state ConnectionState)
+
+	// connection supervises the rsocket client. When the client is closed by anything other than Close it is replaced by dialing
+	// elarian again with a jittered exponential backoff. The connection is closed instead when elarian rejects the credentials or setup.
+	// The sessions of resumable clients are resumed by rsocket on new transports, see resumingTransport.
+	connection struct {
+		dial        func(ctx context.Context, onClose func(error)) (rsocket.Client, error)
+		reportError func(err error)
+		onClosed    func()
+		metrics     MetricsCollector
+
+		initialBackoff time.Duration
+		maxBackoff     time.Duration
+		maxAttempts    int
+		reconnect      bool
+
+		ctx       context.Context
+		cancel    context.CancelFunc
+		lost      chan lostClient
+		closeOnce sync.Once
+
+		mu         sync.RWMutex
+		client     rsocket.Client
+		generation uint64
+		state      ConnectionState
+		handlers   []ConnectionStateHandler
+
+		randMu sync.Mutex
+		rand   *rand.Rand
+	}
+
+	lostClient struct {
+		generation uint64
+		err        error
+	}
+
+	// resumingTransport dials the transport of a resumable client. rsocket dials it again on its own to resume the session when the transport
+	// is lost without closing the client, so the loss and the resumption are reported from here and the reconnect options applied to the attempts.
+	resumingTransport struct {
+		conn       *connection
+		dial       transport.ClientTransporter
+		generation uint64
+
+		mu      sync.Mutex
+		dialed  bool
+		attempt int
+		since   time.Time
+	}
+
+	// watchedConn calls onLost the first time reading from the transport fails, unless elarian closed the session first
+	watchedConn struct {
+		transport.Conn
+		once   sync.Once
+		onLost func()
+	}
+)
+
+// ConnectionState constants
+const (
+	ConnectionStateConnecting ConnectionState = iota
+	ConnectionStateReady
+	ConnectionStateReconnecting
+	ConnectionStateClosed
+)
+
+var (
+	errNotConnected  = errors.New("not connected")
+	errResumeStopped = errors.New("stopped resuming the session")
+)
+
+func (s ConnectionState) String() string {
+	switch s {
+	case ConnectionStateConnecting:
+		return "connecting"
+	case ConnectionStateReady:
+		return "ready"
+	case ConnectionStateReconnecting:
+		return "reconnecting"
+	case ConnectionStateClosed:
+		return "closed"
+	default:
+		return "unknown"
+	}
+}
+
+func newConnection(connectionOptions *ConnectionOptions) *connection {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &connection{
+		initialBackoff: connectionOptions.ReconnectBackoff,
+		maxBackoff:     connectionOptions.MaxReconnectBackoff,
+		maxAttempts:    connectionOptions.MaxReconnectAttempts,
+		reconnect:      !connectionOptions.DisableReconnect,
+		ctx:            ctx,
+		cancel:         cancel,
+		lost:           make(chan lostClient),
+		state:          ConnectionStateConnecting,
+		metrics:        nopMetrics{},
+		rand:           rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// newOfflineConnection returns a closed connection that is never dialed, requests sent on it fail immediately
+func newOfflineConnection() *connection {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &connection{ctx: ctx, cancel: cancel, state: ConnectionStateClosed, metrics: nopMetrics{}}
+}
+
+// start dials elarian for the first time and starts supervising the connection
+func (c *connection) start() error {
+	if err := c.connect(); err != nil {
+		c.cancel()
+		c.mu.Lock()
+		c.state = ConnectionStateClosed
+		c.mu.Unlock()
+		return err
+	}
+	go c.supervise()
+	return nil
+}
+
+func (c *connection) connect() error {
+	c.mu.Lock()
+	c.generation++
+	generation := c.generation
+	c.mu.Unlock()
+
+	onClose := func(err error) {
+		go func() {
+			select {
+			case c.lost <- lostClient{generation: generation, err: err}:
+			case <-c.ctx.Done():
+			}
+		}()
+	}
+	client, err := c.dial(c.ctx, onClose)
+	if err != nil {
+		return newConnectionError(err)
+	}
+
+	c.mu.Lock()
+	if err := c.ctx.Err(); err != nil {
+		c.mu.Unlock()
+		_ = client.Close()
+		return &ConnectionError{Kind: ErrUnreachable, Err: err}
+	}
+	c.client = client
+	c.mu.Unlock()
+	c.setState(ConnectionStateReady)
+	return nil
+}
+
+func (c *connection) supervise() {
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case lost := <-c.lost:
+			c.mu.Lock()
+			current := lost.generation == c.generation
+			if current {
+				c.client = nil
+			}
+			c.mu.Unlock()
+			if !current {
+				continue
+			}
+			if lost.err != nil {
+				err := newConnectionError(lost.err)
+				c.report(err)
+				// elarian rejects the setup once the client is started, dialing again would be rejected the same way
+				if rejected(err) {
+					go c.Close()
+					return
+				}
+			}
+			if !c.reconnect {
+				go c.Close()
+				return
+			}
+			c.setState(ConnectionStateReconnecting)
+			if !c.redial() {
+				go c.Close()
+				return
+			}
+		}
+	}
+}
+
+// redial keeps dialing elarian until it succeeds, the connection is closed or the reconnect attempts are exhausted
+func (c *connection) redial() bool {
+	for attempt := 1; c.maxAttempts == 0 || attempt <= c.maxAttempts; attempt++ {
+		select {
+		case <-c.ctx.Done():
+			return false
+		case <-time.After(c.backoff(attempt)):
+		}
+		err := c.connect()
+		if err == nil {
+			c.metrics.Reconnected()
+			return true
+		}
+		if c.ctx.Err() != nil {
+			return false
+		}
+		c.report(err)
+		if rejected(err) {
+			return false
+		}
+	}
+	return false
+}
+
+// resumable wraps the transport of a resumable client dialed for the current generation of the connection
+func (c *connection) resumable(dial transport.ClientTransporter) transport.ClientTransporter {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	r := &resumingTransport{conn: c, dial: dial, generation: c.generation}
+	return r.dialTransport
+}
+
+// current reports whether the client of the given generation is still in use
+func (c *connection) current(generation uint64) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return generation == c.generation && c.ctx.Err() == nil
+}
+
+// transportLost is called when the transport of a resumable client is lost, the connection is closed instead when reconnecting is disabled
+func (c *connection) transportLost(generation uint64) {
+	if !c.current(generation) {
+		return
+	}
+	if !c.reconnect {
+		go c.Close()
+		return
+	}
+	c.setState(ConnectionStateReconnecting)
+}
+
+// dialTransport dials the transport rsocket sets the client up on the first time and resumes the session on afterwards.
+// Attempts to resume wait for the reconnect backoff from when the transport was lost or the previous attempt failed,
+// on top of the delay rsocket waits before every attempt.
+func (r *resumingTransport) dialTransport(ctx context.Context) (*transport.Transport, error) {
+	r.mu.Lock()
+	resuming := r.dialed
+	if resuming {
+		r.attempt++
+	}
+	attempt, since := r.attempt, r.since
+	r.mu.Unlock()
+
+	c := r.conn
+	if resuming {
+		if !c.current(r.generation) {
+			return nil, errNotConnected
+		}
+		if !c.reconnect || (c.maxAttempts > 0 && attempt > c.maxAttempts) {
+			go c.Close()
+			return nil, errResumeStopped
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-c.ctx.Done():
+			return nil, errNotConnected
+		case <-time.After(time.Until(since.Add(c.backoff(attempt)))):
+		}
+	}
+
+	tp, err := r.dial(ctx)
+	if err != nil {
+		if resuming {
+			r.mu.Lock()
+			r.since = time.Now()
+			r.mu.Unlock()
+			c.report(newConnectionError(err))
+		}
+		return nil, err
+	}
+	r.mu.Lock()
+	r.dialed, r.attempt = true, 0
+	r.mu.Unlock()
+	if resuming {
+		c.metrics.Reconnected()
+		c.setState(ConnectionStateReady)
+	}
+	return transport.NewTransport(&watchedConn{Conn: tp.Connection(), onLost: r.lost}), nil
+}
+
+func (r *resumingTransport) lost() {
+	r.mu.Lock()
+	r.since = time.Now()
+	r.mu.Unlock()
+	r.conn.transportLost(r.generation)
+}
+
+func (w *watchedConn) Read() (core.BufferedFrame, error) {
+	frame, err := w.Conn.Read()
+	if err != nil {
+		w.once.Do(w.onLost)
+		return frame, err
+	}
+	// an error on stream 0 ends the session, which rsocket reports through OnClose
+	if header := frame.Header(); header.StreamID() == 0 && header.Type() == core.FrameTypeError {
+		w.once.Do(func() {})
+	}
+	return frame, nil
+}
+
+// rejected reports whether elarian rejected the credentials or setup of the connection, in which case it is not dialed again
+func rejected(err error) bool {
+	return errors.Is(err, ErrAuthFailed) || errors.Is(err, ErrSetupRejected)
+}
+
+// backoff returns the delay before the given reconnect attempt. It grows exponentially and half of it is randomized
+func (c *connection) backoff(attempt int) time.Duration {
+	delay := c.initialBackoff
+	for i := 1; i < attempt && delay < c.maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > c.maxBackoff {
+		delay = c.maxBackoff
+	}
+	c.randMu.Lock()
+	defer c.randMu.Unlock()
+	return delay/2 + time.Duration(c.rand.Int63n(int64(delay/2)+1))
+}
+
+func (c *connection) setState(state ConnectionState) {
+	c.mu.Lock()
+	if c.state == state || c.state == ConnectionStateClosed {
+		c.mu.Unlock()
+		return
+	}
+	c.state = state
+	handlers := append([]ConnectionStateHandler{}, c.handlers...)
+	c.mu.Unlock()
+	for _, handler := range handlers {
+		handler(state)
+	}
+}
+
+// report hands an error to reportError unless the connection is closed, in which case the error channel may already be closed
+func (c *connection) report(err error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.state != ConnectionStateClosed && c.reportError != nil {
+		c.reportError(err)
+	}
+}
+
+// State returns the current connection state
+func (c *connection) State() ConnectionState {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.state
+}
+
+// OnStateChange registers a handler that is called on every connection state change
+func (c *connection) OnStateChange(handler ConnectionStateHandler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.handlers = append(c.handlers, handler)
+}
+
+// RequestResponse sends a request on the current client. It fails immediately when elarian is not connected
+func (c *connection) RequestResponse(message payload.Payload) mono.Mono {
+	c.mu.RLock()
+	client := c.client
+	c.mu.RUnlock()
+	if client == nil {
+		return mono.Error(&ConnectionError{Kind: ErrUnreachable, Err: errNotConnected})
+	}
+	return client.RequestResponse(message)
+}
+
+// Close stops reconnecting and closes the current client
+func (c *connection) Close() (err error) {
+	c.closeOnce.Do(func() {
+		c.cancel()
+		c.mu.Lock()
+		client := c.client
+		c.client = nil
+		c.mu.Unlock()
+		if client != nil {
+			err = client.Close()
+		}
+		c.setState(ConnectionStateClosed)
+		if c.onClosed != nil {
+			c.onClosed()
+		}
+	})
+	return err
+}
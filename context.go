@@ -0,0 +1,113 @@
+package elarian
+
+import (
+	"context"
+	"time"
+
+	hera "github.com/elarianltd/go-sdk/com_elarian_hera_proto"
+)
+
+type (
+	contextKey int
+
+	// NotificationEnvelope describes the notification frame a handler context was created for.
+	// CustomerID is empty for purse notifications and PurseID for customer notifications.
+	// Raw is the frame as it was received from elarian, Simulator is set instead for simulator notifications which carry no org, app or customer
+	NotificationEnvelope struct {
+		OrgID      string                              `json:"orgId,omitempty"`
+		AppID      string                              `json:"appId,omitempty"`
+		CustomerID string                              `json:"customerId,omitempty"`
+		PurseID    string                              `json:"purseId,omitempty"`
+		CreatedAt  time.Time                           `json:"createdAt,omitempty"`
+		Raw        *hera.ServerToAppNotification       `json:"-"`
+		Simulator  *hera.ServerToSimulatorNotification `json:"-"`
+	}
+)
+
+const (
+	envelopeContextKey contextKey = iota
+	notificationContextKey
+	requestContextKey
+	dedupContextKey
+)
+
+// EnvelopeFromContext returns the envelope of the notification a handler context was created for
+func EnvelopeFromContext(ctx context.Context) (*NotificationEnvelope, bool) {
+	envelope, ok := ctx.Value(envelopeContextKey).(*NotificationEnvelope)
+	return envelope, ok
+}
+
+// OrgIDFromContext returns the org ID of the notification a handler context was created for
+func OrgIDFromContext(ctx context.Context) string {
+	envelope, _ := EnvelopeFromContext(ctx)
+	return envelope.orgID()
+}
+
+// AppIDFromContext returns the app ID of the notification a handler context was created for
+func AppIDFromContext(ctx context.Context) string {
+	envelope, _ := EnvelopeFromContext(ctx)
+	return envelope.appID()
+}
+
+// CustomerIDFromContext returns the ID of the customer a notification handler context was created for, it is empty for notifications that are not about a customer
+func CustomerIDFromContext(ctx context.Context) string {
+	envelope, _ := EnvelopeFromContext(ctx)
+	return envelope.customerID()
+}
+
+// NotificationFromContext returns the kind of notification a handler context was created for
+func NotificationFromContext(ctx context.Context) (Notification, bool) {
+	notification, ok := ctx.Value(notificationContextKey).(Notification)
+	return notification, ok
+}
+
+func (e *NotificationEnvelope) orgID() string {
+	if e == nil {
+		return ""
+	}
+	return e.OrgID
+}
+
+func (e *NotificationEnvelope) appID() string {
+	if e == nil {
+		return ""
+	}
+	return e.AppID
+}
+
+func (e *NotificationEnvelope) customerID() string {
+	if e == nil {
+		return ""
+	}
+	return e.CustomerID
+}
+
+// newNotificationEnvelope reads the envelope of a notification frame received from elarian
+func newNotificationEnvelope(notf *hera.ServerToAppNotification) *NotificationEnvelope {
+	envelope := &NotificationEnvelope{Raw: notf}
+	if customer := notf.GetCustomer(); customer != nil {
+		envelope.OrgID, envelope.AppID, envelope.CustomerID = customer.GetOrgId(), customer.GetAppId(), customer.GetCustomerId()
+		if customer.GetCreatedAt() != nil {
+			envelope.CreatedAt = customer.GetCreatedAt().AsTime()
+		}
+	}
+	if purse := notf.GetPurse(); purse != nil {
+		envelope.OrgID, envelope.AppID, envelope.PurseID = purse.GetOrgId(), purse.GetAppId(), purse.GetPurseId()
+		if purse.GetCreatedAt() != nil {
+			envelope.CreatedAt = purse.GetCreatedAt().AsTime()
+		}
+	}
+	return envelope
+}
+
+// notificationContext returns the context handlers of a notification are called with
+func notificationContext(parent context.Context, envelope *NotificationEnvelope) context.Context {
+	ctx := context.WithValue(parent, envelopeContextKey, envelope)
+	return context.WithValue(ctx, dedupContextKey, &dedupDecisions{decisions: make(map[dedupDecisionKey]dedupDecision)})
+}
+
+// requestFromContext returns the request a handler context was created for, it is missing for simulator notifications
+func requestFromContext(ctx context.Context) (*notificationRequest, bool) {
+	req, ok := ctx.Value(requestContextKey).(*notificationRequest)
+	return req, ok
+}
@@ -0,0 +1,212 @@
+package elarian
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	hera "github.com/elarianltd/go-sdk/com_elarian_hera_proto"
+)
+
+type (
+	// CustomerLookup decides how the customer number is found for notifications that do not carry one, such as reminders
+	CustomerLookup int32
+
+	// customerCache remembers the customer numbers seen in notifications and customer states so that they are not fetched for every notification.
+	// It holds copies of the numbers and forgets the least recently used customer once it is full.
+	// Concurrent lookups of the same customer share a single GetCustomerState request.
+	customerCache struct {
+		mu       sync.Mutex
+		ttl      time.Duration
+		order    *list.List
+		entries  map[string]*list.Element
+		inflight map[string]*customerLookupCall
+		fetch    func(ctx context.Context, customerID string) (*CustomerNumber, error)
+	}
+
+	customerCacheEntry struct {
+		customerID string
+		number     CustomerNumber
+		expires    time.Time
+	}
+
+	customerLookupCall struct {
+		done   chan struct{}
+		number *CustomerNumber
+		err    error
+	}
+)
+
+// CustomerLookup constants
+const (
+	// CustomerLookupCached fetches the state of customers whose number is not cached before their reminders are dispatched
+	CustomerLookupCached CustomerLookup = iota
+
+	// CustomerLookupLazy only fills in cached customer numbers, handlers that need the number call Customer.ResolveCustomerNumber
+	CustomerLookupLazy
+
+	// CustomerLookupOff never looks customer numbers up, handlers get the customer number only when it is part of the notification
+	CustomerLookupOff
+)
+
+const (
+	defaultCustomerCacheTTL time.Duration = time.Minute * 10
+	maxCustomerCacheEntries int           = 10000
+)
+
+func newCustomerCache(ttl time.Duration, fetch func(ctx context.Context, customerID string) (*CustomerNumber, error)) *customerCache {
+	if ttl <= 0 {
+		ttl = defaultCustomerCacheTTL
+	}
+	return &customerCache{
+		ttl:      ttl,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+		inflight: make(map[string]*customerLookupCall),
+		fetch:    fetch,
+	}
+}
+
+// get returns a copy of the cached number of a customer, it is nil when the number is not cached or has expired
+func (c *customerCache) get(customerID string) *CustomerNumber {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[customerID]
+	if !ok {
+		return nil
+	}
+	entry := element.Value.(*customerCacheEntry)
+	if time.Now().After(entry.expires) {
+		c.order.Remove(element)
+		delete(c.entries, customerID)
+		return nil
+	}
+	c.order.MoveToFront(element)
+	// handlers get their own copy so that changing it does not change the cached number
+	number := entry.number
+	return &number
+}
+
+// put caches a copy of the number of a customer, the least recently used customer is forgotten once the cache is full
+func (c *customerCache) put(customerID string, number *CustomerNumber) {
+	if customerID == "" || number == nil || number.Number == "" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	expires := time.Now().Add(c.ttl)
+	if element, ok := c.entries[customerID]; ok {
+		entry := element.Value.(*customerCacheEntry)
+		entry.number, entry.expires = *number, expires
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[customerID] = c.order.PushFront(&customerCacheEntry{customerID: customerID, number: *number, expires: expires})
+	if c.order.Len() > maxCustomerCacheEntries {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*customerCacheEntry).customerID)
+	}
+}
+
+// lookup returns the number of a customer from the cache or fetches it
+func (c *customerCache) lookup(ctx context.Context, customerID string) (*CustomerNumber, error) {
+	if number := c.get(customerID); number != nil {
+		return number, nil
+	}
+	c.mu.Lock()
+	call, ok := c.inflight[customerID]
+	if !ok {
+		call = &customerLookupCall{done: make(chan struct{})}
+		c.inflight[customerID] = call
+		c.mu.Unlock()
+		call.number, call.err = c.fetch(ctx, customerID)
+		c.mu.Lock()
+		delete(c.inflight, customerID)
+		c.mu.Unlock()
+		close(call.done)
+		if call.err == nil {
+			c.put(customerID, call.number)
+		}
+		return call.number, call.err
+	}
+	c.mu.Unlock()
+	select {
+	case <-call.done:
+		if call.number == nil {
+			return nil, call.err
+		}
+		// every caller sharing the request gets its own copy of the number
+		number := *call.number
+		return &number, call.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// fetchCustomerNumber reads the customer number from the customer's state, it is nil when the state has none
+func (s *elarian) fetchCustomerNumber(ctx context.Context, customerID string) (*CustomerNumber, error) {
+	state, err := s.GetCustomerState(ctx, CustomerID(customerID))
+	if err != nil {
+		return nil, err
+	}
+	return s.customerNumberFromState(state), nil
+}
+
+func (s *elarian) customerNumberFromState(state *hera.GetCustomerStateReply) *CustomerNumber {
+	data := state.GetData()
+	if customerNumbers := data.GetActivityState().GetCustomerNumbers(); len(customerNumbers) > 0 {
+		return s.customerNumber(customerNumbers[0])
+	}
+	if channels := data.GetMessagingState().GetChannels(); len(channels) > 0 && channels[0].GetActive().GetCustomerNumber() != nil {
+		return s.customerNumber(channels[0].GetActive().GetCustomerNumber())
+	}
+	return nil
+}
+
+// withCustomerNumber fills in the number of a customer from a notification that does not carry it according to the CustomerLookup option.
+// A customer whose number cannot be fetched is dispatched without it.
+func (s *elarian) withCustomerNumber(ctx context.Context, customer *Customer, fetch bool) {
+	switch {
+	case s.customerLookup == CustomerLookupOff || customer.CustomerNumber != nil:
+		return
+	case fetch && s.customerLookup == CustomerLookupCached:
+		customer.CustomerNumber, _ = s.customers.lookup(ctx, customer.ID)
+	default:
+		customer.CustomerNumber = s.customers.get(customer.ID)
+	}
+}
+
+// rememberCustomerNumber caches the number of a customer from a notification that carries it
+func (s *elarian) rememberCustomerNumber(customer *Customer) {
+	if s.customerLookup != CustomerLookupOff {
+		s.customers.put(customer.ID, customer.CustomerNumber)
+	}
+}
+
+// ResolveCustomerNumber fills in the customer number from the customer's state on elarian when it is not set, it is nil when elarian has none.
+// Numbers are shared with the notification handlers through the customer cache unless Options.CustomerLookup is CustomerLookupOff
+func (c *Customer) ResolveCustomerNumber(ctx context.Context) (*CustomerNumber, error) {
+	if c.CustomerNumber != nil {
+		return c.CustomerNumber, nil
+	}
+	s, ok := c.service.(*elarian)
+	if !ok {
+		return nil, errNotConnected
+	}
+	var (
+		number *CustomerNumber
+		err    error
+	)
+	if s.customerLookup == CustomerLookupOff {
+		number, err = s.fetchCustomerNumber(ctx, c.ID)
+	} else {
+		number, err = s.customers.lookup(ctx, c.ID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.CustomerNumber = number
+	return number, nil
+}
@@ -0,0 +1,315 @@
+package elarian
+
+import (
+	"bufio"
+	"container/list"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	hera "github.com/elarianltd/go-sdk/com_elarian_hera_proto"
+)
+
+type (
+	// DedupStore remembers the notifications that have been handled so that notifications redelivered by elarian are only handled once.
+	// A key is added when its notification is dispatched and removed again when its handlers fail, or return without replying before the default reply is sent,
+	// so that elarian can redeliver it.
+	// Add and Remove must be safe to call from multiple goroutines.
+	DedupStore interface {
+		// Add records key and reports whether it was new, it returns false for a key that was already added
+		Add(ctx context.Context, key string) (bool, error)
+
+		// Remove forgets key, it is not an error to remove a key that is not in the store
+		Remove(ctx context.Context, key string) error
+	}
+
+	// LRUDedupStore is an in memory DedupStore that remembers the most recently added keys up to its size
+	LRUDedupStore struct {
+		mu    sync.Mutex
+		size  int
+		order *list.List
+		keys  map[string]*list.Element
+	}
+
+	// FileDedupStore is a DedupStore that appends keys, and the keys it removes, to a file so that they are remembered across restarts.
+	// It remembers the most recently added keys up to its size and compacts the file once it holds twice as many.
+	FileDedupStore struct {
+		mu    sync.Mutex
+		path  string
+		file  *os.File
+		lines int
+		keys  *LRUDedupStore
+	}
+)
+
+const defaultDedupStoreSize int = 10000
+
+// dedupRemoved prefixes the keys removed from a FileDedupStore in its file
+const dedupRemoved string = "-"
+
+// NewLRUDedupStore returns an in memory DedupStore, size defaults to 10000 keys
+func NewLRUDedupStore(size int) *LRUDedupStore {
+	if size <= 0 {
+		size = defaultDedupStoreSize
+	}
+	return &LRUDedupStore{size: size, order: list.New(), keys: make(map[string]*list.Element)}
+}
+
+// Add records key and reports whether it was new, the least recently added key is forgotten once the store is full
+func (s *LRUDedupStore) Add(ctx context.Context, key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.add(key), nil
+}
+
+// Remove forgets key
+func (s *LRUDedupStore) Remove(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remove(key)
+	return nil
+}
+
+func (s *LRUDedupStore) remove(key string) {
+	if element, ok := s.keys[key]; ok {
+		s.order.Remove(element)
+		delete(s.keys, key)
+	}
+}
+
+func (s *LRUDedupStore) add(key string) bool {
+	if element, ok := s.keys[key]; ok {
+		s.order.MoveToFront(element)
+		return false
+	}
+	s.keys[key] = s.order.PushFront(key)
+	if s.order.Len() > s.size {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.keys, oldest.Value.(string))
+	}
+	return true
+}
+
+// recent returns the keys from the least to the most recently added
+func (s *LRUDedupStore) recent() []string {
+	keys := make([]string, 0, s.order.Len())
+	for element := s.order.Back(); element != nil; element = element.Prev() {
+		keys = append(keys, element.Value.(string))
+	}
+	return keys
+}
+
+// NewFileDedupStore opens or creates the file at path and loads the keys already in it, size defaults to 10000 keys
+func NewFileDedupStore(path string, size int) (*FileDedupStore, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("opening dedup store: %w", err)
+	}
+	store := &FileDedupStore{path: path, file: file, keys: NewLRUDedupStore(size)}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			continue
+		case strings.HasPrefix(line, dedupRemoved):
+			store.keys.remove(strings.TrimPrefix(line, dedupRemoved))
+		default:
+			store.keys.add(line)
+		}
+		store.lines++
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("reading dedup store: %w", err)
+	}
+	return store, nil
+}
+
+// Add records key and appends it to the file, it reports whether the key was new
+func (s *FileDedupStore) Add(ctx context.Context, key string) (bool, error) {
+	if err := validDedupKey(key); err != nil {
+		return false, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return false, os.ErrClosed
+	}
+	if !s.keys.add(key) {
+		return false, nil
+	}
+	return true, s.write(key)
+}
+
+// Remove forgets key and appends its removal to the file
+func (s *FileDedupStore) Remove(ctx context.Context, key string) error {
+	if err := validDedupKey(key); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return os.ErrClosed
+	}
+	if _, ok := s.keys.keys[key]; !ok {
+		return nil
+	}
+	s.keys.remove(key)
+	return s.write(dedupRemoved + key)
+}
+
+// write appends a line to the file and compacts it once it holds twice as many lines as the keys the store remembers
+func (s *FileDedupStore) write(line string) error {
+	if _, err := s.file.WriteString(line + "\n"); err != nil {
+		return fmt.Errorf("writing dedup store: %w", err)
+	}
+	if err := s.file.Sync(); err != nil {
+		return fmt.Errorf("syncing dedup store: %w", err)
+	}
+	s.lines++
+	if s.lines >= 2*s.keys.size {
+		return s.compact()
+	}
+	return nil
+}
+
+// validDedupKey checks that a key can be written to a line of the file
+func validDedupKey(key string) error {
+	if strings.ContainsAny(key, "\r\n") {
+		return fmt.Errorf("dedup key %q contains a line break", key)
+	}
+	if strings.HasPrefix(key, dedupRemoved) {
+		return fmt.Errorf("dedup key %q starts with %q", key, dedupRemoved)
+	}
+	return nil
+}
+
+// compact rewrites the file with only the keys that are still remembered
+func (s *FileDedupStore) compact() error {
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return fmt.Errorf("compacting dedup store: %w", err)
+	}
+	keys := s.keys.recent()
+	writer := bufio.NewWriter(tmp)
+	for _, key := range keys {
+		writer.WriteString(key + "\n")
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("compacting dedup store: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("compacting dedup store: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("compacting dedup store: %w", err)
+	}
+	s.file.Close()
+	s.file, s.lines = tmp, len(keys)
+	return nil
+}
+
+// Close closes the file, keys can no longer be added afterwards
+func (s *FileDedupStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// notificationID returns the key a notification is deduplicated on, it is empty for notifications that have nothing that identifies them
+func notificationID(notf *hera.ServerToAppNotification) string {
+	if purse := notf.GetPurse(); purse != nil {
+		status := purse.GetPaymentStatus()
+		return identity("purse-payment-status", status.GetTransactionId(), status.GetStatus())
+	}
+	customer := notf.GetCustomer()
+	switch entry := customer.GetEntry().(type) {
+	case *hera.ServerToAppCustomerNotification_Reminder:
+		return identity("reminder", entry.Reminder.GetWorkId().GetValue())
+	case *hera.ServerToAppCustomerNotification_MessagingSessionStarted:
+		return identity("messaging-session-started", entry.MessagingSessionStarted.GetSessionId())
+	case *hera.ServerToAppCustomerNotification_MessagingSessionEnded:
+		return identity("messaging-session-ended", entry.MessagingSessionEnded.GetSessionId())
+	case *hera.ServerToAppCustomerNotification_ReceivedMessage:
+		return identity("received-message", entry.ReceivedMessage.GetMessageId())
+	case *hera.ServerToAppCustomerNotification_MessageStatus:
+		return identity("message-status", entry.MessageStatus.GetMessageId(), entry.MessageStatus.GetStatus())
+	case *hera.ServerToAppCustomerNotification_SentMessageReaction:
+		return identity("sent-message-reaction", entry.SentMessageReaction.GetMessageId(), entry.SentMessageReaction.GetReaction())
+	case *hera.ServerToAppCustomerNotification_ReceivedPayment:
+		return identity("received-payment", entry.ReceivedPayment.GetTransactionId())
+	case *hera.ServerToAppCustomerNotification_PaymentStatus:
+		return identity("payment-status", entry.PaymentStatus.GetTransactionId(), entry.PaymentStatus.GetStatus())
+	case *hera.ServerToAppCustomerNotification_WalletPaymentStatus:
+		return identity("wallet-payment-status", entry.WalletPaymentStatus.GetTransactionId(), entry.WalletPaymentStatus.GetStatus())
+	default:
+		return ""
+	}
+}
+
+// identity joins the kind, id and status of a notification into a key, it is empty when id is empty
+func identity(kind, id string, status ...interface{}) string {
+	if id == "" {
+		return ""
+	}
+	key := kind + ":" + id
+	for _, s := range status {
+		key += fmt.Sprintf(":%d", s)
+	}
+	return key
+}
+
+// duplicate reports whether the notification was already handled. Errors from the store are reported and the notification is handled anyway
+func (s *elarian) duplicate(ctx context.Context, req *notificationRequest) bool {
+	// replayed notifications were added to the store before the service stopped without handling them
+	if s.dedup == nil || req.replayed {
+		return false
+	}
+	key := notificationID(req.notification)
+	if key == "" {
+		return false
+	}
+	added, err := s.dedup.Add(ctx, key)
+	if err != nil {
+		s.reportError(fmt.Errorf("deduplicating notification %s: %w", key, err))
+		return false
+	}
+	if added {
+		req.mu.Lock()
+		req.dedupKey = key
+		req.mu.Unlock()
+	}
+	return !added
+}
+
+// forget removes a notification that was not handled from the dedup store so that it is handled when elarian delivers it again.
+// It is called when a handler fails or returns without replying before the default reply is sent, errors from the store are reported
+func (s *elarian) forget(req *notificationRequest) {
+	req.mu.Lock()
+	key := req.dedupKey
+	req.dedupKey = ""
+	req.mu.Unlock()
+	if key == "" {
+		return
+	}
+	if err := s.dedup.Remove(context.Background(), key); err != nil {
+		s.reportError(fmt.Errorf("forgetting notification %s: %w", key, err))
+	}
+}
@@ -0,0 +1,153 @@
+package elarian
+
+import (
+	"context"
+	"errors"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+type (
+	// Subscription is returned when a handler is registered with On or Once and can be used to remove it
+	Subscription struct {
+		event      Notification
+		handler    NotificationHandler
+		once       bool
+		dispatcher *dispatcher
+	}
+
+	// dispatcher calls the handlers registered for a notification in the order they were registered.
+	// onError is called with the callback of the notification for every handler that returns an error or panics, onFinished with how long every handler took
+	dispatcher struct {
+		mu          sync.RWMutex
+		handlers    map[Notification][]*Subscription
+		middlewares []Middleware
+		onError     func(ctx context.Context, err *HandlerError, cb NotificationCallBack)
+		onFinished  func(event Notification, duration time.Duration, err error)
+	}
+)
+
+func newDispatcher() *dispatcher {
+	return &dispatcher{handlers: make(map[Notification][]*Subscription)}
+}
+
+// Off removes the handler, it is safe to call it more than once
+func (sub *Subscription) Off() {
+	if sub != nil && sub.dispatcher != nil {
+		sub.dispatcher.unsubscribe(sub)
+	}
+}
+
+func (d *dispatcher) subscribe(event Notification, handler NotificationHandler, once bool) *Subscription {
+	sub := &Subscription{event: event, handler: handler, once: once, dispatcher: d}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.handlers[event] = append(d.handlers[event], sub)
+	return sub
+}
+
+func (d *dispatcher) unsubscribe(sub *Subscription) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	handlers := d.handlers[sub.event]
+	for i, handler := range handlers {
+		if handler == sub {
+			d.handlers[sub.event] = append(handlers[:i:i], handlers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// subscribed reports whether any handler is registered for the notification
+func (d *dispatcher) subscribed(event Notification) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return len(d.handlers[event]) > 0
+}
+
+// publish calls every handler registered for the notification through the middlewares. Handlers registered with Once are removed before they are called
+func (d *dispatcher) publish(ctx context.Context, event Notification, service Elarian, notification IsNotification, appData *Appdata, customer *Customer, cb NotificationCallBack) {
+	d.mu.RLock()
+	handlers := append([]*Subscription{}, d.handlers[event]...)
+	middlewares := d.middlewares
+	d.mu.RUnlock()
+
+	ctx = context.WithValue(ctx, notificationContextKey, event)
+	for _, sub := range handlers {
+		if sub.once && !d.unsubscribe(sub) {
+			continue
+		}
+		handler := chain(middlewares, sub.handler)
+		started := time.Now()
+		err := callHandler(ctx, event, handler, service, notification, appData, customer, cb)
+		if d.onFinished != nil {
+			d.onFinished(event, time.Since(started), err)
+		}
+		var handlerErr *HandlerError
+		if errors.As(err, &handlerErr) && d.onError != nil {
+			d.onError(ctx, handlerErr, cb)
+		}
+	}
+}
+
+// use appends middlewares to the chain every handler is called through
+func (d *dispatcher) use(middlewares ...Middleware) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.middlewares = append(d.middlewares[:len(d.middlewares):len(d.middlewares)], middlewares...)
+}
+
+// chain wraps the handler with the middlewares, the first middleware is the outermost
+func chain(middlewares []Middleware, handler NotificationHandler) NotificationHandler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+// callHandler runs the handler and recovers from any panic in it so that the remaining handlers and notifications are still handled.
+// The error it returns is nil or a *HandlerError
+func callHandler(ctx context.Context, event Notification, handler NotificationHandler, service Elarian, notification IsNotification, appData *Appdata, customer *Customer, cb NotificationCallBack) (err error) {
+	defer func() {
+		if value := recover(); value != nil {
+			err = handlerPanic(ctx, event, value)
+		}
+	}()
+	if err = handler(ctx, service, notification, appData, customer, cb); err == nil {
+		return nil
+	}
+	var handlerErr *HandlerError
+	if errors.As(err, &handlerErr) && handlerErr != nil {
+		return handlerErr
+	}
+	return &HandlerError{Notification: event, CustomerID: CustomerIDFromContext(ctx), Err: err}
+}
+
+// handlerPanic describes a handler that panicked with value, it must be called from the deferred function that recovered the panic
+func handlerPanic(ctx context.Context, event Notification, value interface{}) *HandlerError {
+	return &HandlerError{
+		Notification: event,
+		CustomerID:   CustomerIDFromContext(ctx),
+		Err:          newPanicError(value),
+		Panic:        value,
+		Stack:        debug.Stack(),
+	}
+}
+
+func (s *elarian) On(notification Notification, handler NotificationHandler) *Subscription {
+	return s.bus.subscribe(notification, handler, false)
+}
+
+func (s *elarian) Once(notification Notification, handler NotificationHandler) *Subscription {
+	return s.bus.subscribe(notification, handler, true)
+}
+
+func (s *elarian) Off(subscription *Subscription) {
+	subscription.Off()
+}
+
+func (s *elarian) Use(middlewares ...Middleware) {
+	s.bus.use(middlewares...)
+}
@@ -7,8 +7,6 @@ import (
 	"time"
 
 	hera "github.com/elarianltd/go-sdk/com_elarian_hera_proto"
-	"github.com/golang/protobuf/proto"
-	"github.com/rsocket/rsocket-go/payload"
 	"google.golang.org/protobuf/types/known/durationpb"
 	"google.golang.org/protobuf/types/known/timestamppb"
 	"google.golang.org/protobuf/types/known/wrapperspb"
@@ -224,18 +222,10 @@ func (s *elarian) GetCustomerState(ctx context.Context, customer IsCustomer) (*h
 	req := &hera.AppToServerCommand{
 		Entry: &hera.AppToServerCommand_GetCustomerState{GetCustomerState: command},
 	}
-	data, err := proto.Marshal(req)
+	reply, err := s.sendCommand(ctx, req)
 	if err != nil {
 		return nil, err
 	}
-	payload, err := s.client.RequestResponse(payload.New(data, []byte{})).Block(ctx)
-	if err != nil {
-		return nil, err
-	}
-	reply := &hera.AppToServerCommandReply{}
-	if err = proto.Unmarshal(payload.Data(), reply); err != nil {
-		return nil, err
-	}
 	return reply.GetGetCustomerState(), err
 }
 
@@ -263,18 +253,10 @@ func (s *elarian) GetCustomerActivity(ctx context.Context, customerNumber *Custo
 	req := &hera.AppToServerCommand{
 		Entry: &hera.AppToServerCommand_CustomerActivity{CustomerActivity: command},
 	}
-	data, err := proto.Marshal(req)
-	if err != nil {
-		return nil, err
-	}
-	payload, err := s.client.RequestResponse(payload.New(data, []byte{})).Block(ctx)
+	reply, err := s.sendCommand(ctx, req)
 	if err != nil {
 		return nil, err
 	}
-	reply := new(hera.AppToServerCommandReply)
-	if err = proto.Unmarshal(payload.Data(), reply); err != nil {
-		return nil, err
-	}
 	return &CustomerActivityReply{
 		Status:      reply.GetCustomerActivity().Status,
 		Description: reply.GetCustomerActivity().Description,
@@ -309,18 +291,10 @@ func (s *elarian) UpdateCustomerActivity(ctx context.Context, customerNumber *Cu
 	req := &hera.AppToServerCommand{
 		Entry: &hera.AppToServerCommand_CustomerActivity{CustomerActivity: command},
 	}
-	data, err := proto.Marshal(req)
-	if err != nil {
-		return nil, err
-	}
-	payload, err := s.client.RequestResponse(payload.New(data, []byte{})).Block(ctx)
+	reply, err := s.sendCommand(ctx, req)
 	if err != nil {
 		return nil, err
 	}
-	reply := &hera.AppToServerCommandReply{}
-	if err := proto.Unmarshal(payload.Data(), reply); err != nil {
-		return nil, err
-	}
 	return &CustomerActivityReply{
 		Status:      reply.GetCustomerActivity().Status,
 		Description: reply.GetCustomerActivity().Description,
@@ -355,19 +329,10 @@ func (s *elarian) AdoptCustomerState(ctx context.Context, customerID string, oth
 		Entry: &hera.AppToServerCommand_AdoptCustomerState{AdoptCustomerState: command},
 	}
 
-	data, err := proto.Marshal(req)
-	if err != nil {
-		return nil, err
-	}
-
-	res, err := s.client.RequestResponse(payload.New(data, []byte{})).Block(ctx)
+	reply, err := s.sendCommand(ctx, req)
 	if err != nil {
 		return nil, err
 	}
-	reply := &hera.AppToServerCommandReply{}
-	if err := proto.Unmarshal(res.Data(), reply); err != nil {
-		return nil, err
-	}
 	return &UpdateCustomerStateReply{
 		Status:      reply.GetUpdateCustomerState().Status,
 		Description: reply.GetUpdateCustomerState().Description,
@@ -408,19 +373,10 @@ func (s *elarian) AddCustomerReminder(ctx context.Context, customer IsCustomer,
 	req := &hera.AppToServerCommand{
 		Entry: &hera.AppToServerCommand_AddCustomerReminder{AddCustomerReminder: command},
 	}
-	d, err := proto.Marshal(req)
+	reply, err := s.sendCommand(ctx, req)
 	if err != nil {
 		return nil, err
 	}
-
-	res, err := s.client.RequestResponse(payload.New(d, []byte{})).Block(ctx)
-	if err != nil {
-		return nil, err
-	}
-	reply := new(hera.AppToServerCommandReply)
-	if err = proto.Unmarshal(res.Data(), reply); err != nil {
-		return nil, err
-	}
 	return &UpdateCustomerAppDataReply{
 		Status:      reply.GetUpdateCustomerAppData().Status,
 		Description: reply.GetUpdateCustomerAppData().Description,
@@ -452,19 +408,10 @@ func (s *elarian) AddCustomerReminderByTag(ctx context.Context, tag *Tag, remind
 	req := &hera.AppToServerCommand{
 		Entry: &hera.AppToServerCommand_AddCustomerReminderTag{AddCustomerReminderTag: command},
 	}
-	data, err := proto.Marshal(req)
-	if err != nil {
-		return nil, err
-	}
-
-	res, err := s.client.RequestResponse(payload.New(data, []byte{})).Block(ctx)
+	reply, err := s.sendCommand(ctx, req)
 	if err != nil {
 		return nil, err
 	}
-	reply := new(hera.AppToServerCommandReply)
-	if err = proto.Unmarshal(res.Data(), reply); err != nil {
-		return nil, err
-	}
 	return &TagCommandReply{
 		Status:      reply.GetTagCommand().Status,
 		Description: reply.GetTagCommand().Description,
@@ -495,18 +442,10 @@ func (s *elarian) CancelCustomerReminder(ctx context.Context, customer IsCustome
 	req := &hera.AppToServerCommand{
 		Entry: &hera.AppToServerCommand_CancelCustomerReminder{CancelCustomerReminder: command},
 	}
-	data, err := proto.Marshal(req)
-	if err != nil {
-		return nil, err
-	}
-	res, err := s.client.RequestResponse(payload.New(data, []byte{})).Block(ctx)
+	reply, err := s.sendCommand(ctx, req)
 	if err != nil {
 		return nil, err
 	}
-	reply := new(hera.AppToServerCommandReply)
-	if err = proto.Unmarshal(res.Data(), reply); err != nil {
-		return nil, err
-	}
 	return &UpdateCustomerAppDataReply{
 		Status:      reply.GetUpdateCustomerAppData().Status,
 		Description: reply.GetUpdateCustomerAppData().Description,
@@ -528,18 +467,10 @@ func (s *elarian) CancelCustomerReminderByTag(ctx context.Context, tag *Tag, key
 	req := &hera.AppToServerCommand{
 		Entry: &hera.AppToServerCommand_CancelCustomerReminderTag{CancelCustomerReminderTag: command},
 	}
-	data, err := proto.Marshal(req)
-	if err != nil {
-		return nil, err
-	}
-	res, err := s.client.RequestResponse(payload.New(data, []byte{})).Block(ctx)
+	reply, err := s.sendCommand(ctx, req)
 	if err != nil {
 		return nil, err
 	}
-	reply := new(hera.AppToServerCommandReply)
-	if err = proto.Unmarshal(res.Data(), reply); err != nil {
-		return nil, err
-	}
 	return &TagCommandReply{
 		Status:      reply.GetTagCommand().Status,
 		Description: reply.GetTagCommand().Description,
@@ -581,19 +512,10 @@ func (s *elarian) UpdateCustomerTag(ctx context.Context, customer IsCustomer, ta
 	req := &hera.AppToServerCommand{
 		Entry: &hera.AppToServerCommand_UpdateCustomerTag{UpdateCustomerTag: command},
 	}
-	data, err := proto.Marshal(req)
+	reply, err := s.sendCommand(ctx, req)
 	if err != nil {
 		return nil, err
 	}
-	res, err := s.client.RequestResponse(payload.New(data, []byte{})).Block(ctx)
-	if err != nil {
-		return nil, err
-	}
-
-	reply := new(hera.AppToServerCommandReply)
-	if err = proto.Unmarshal(res.Data(), reply); err != nil {
-		return nil, err
-	}
 	return &UpdateCustomerStateReply{
 		Status:      reply.GetUpdateCustomerState().Status,
 		Description: reply.GetUpdateCustomerState().Description,
@@ -623,18 +545,10 @@ func (s *elarian) DeleteCustomerTag(ctx context.Context, customer IsCustomer, ke
 	req := &hera.AppToServerCommand{
 		Entry: &hera.AppToServerCommand_DeleteCustomerTag{DeleteCustomerTag: command},
 	}
-	data, err := proto.Marshal(req)
-	if err != nil {
-		return nil, err
-	}
-	res, err := s.client.RequestResponse(payload.New(data, []byte{})).Block(ctx)
+	reply, err := s.sendCommand(ctx, req)
 	if err != nil {
 		return nil, err
 	}
-	reply := new(hera.AppToServerCommandReply)
-	if err = proto.Unmarshal(res.Data(), reply); err != nil {
-		return nil, err
-	}
 	return &UpdateCustomerStateReply{
 		Status:      reply.GetUpdateCustomerState().Status,
 		Description: reply.GetUpdateCustomerState().Description,
@@ -677,18 +591,10 @@ func (s *elarian) UpdateCustomerSecondaryID(ctx context.Context, customer IsCust
 	req := &hera.AppToServerCommand{
 		Entry: &hera.AppToServerCommand_UpdateCustomerSecondaryId{UpdateCustomerSecondaryId: command},
 	}
-	data, err := proto.Marshal(req)
-	if err != nil {
-		return nil, err
-	}
-	res, err := s.client.RequestResponse(payload.New(data, []byte{})).Block(ctx)
+	reply, err := s.sendCommand(ctx, req)
 	if err != nil {
 		return nil, err
 	}
-	reply := new(hera.AppToServerCommandReply)
-	if err = proto.Unmarshal(res.Data(), reply); err != nil {
-		return nil, err
-	}
 	return &UpdateCustomerStateReply{
 		Status:      reply.GetUpdateCustomerState().Status,
 		Description: reply.GetUpdateCustomerState().Description,
@@ -726,18 +632,10 @@ func (s *elarian) DeleteCustomerSecondaryID(ctx context.Context, customer IsCust
 	req := &hera.AppToServerCommand{
 		Entry: &hera.AppToServerCommand_DeleteCustomerSecondaryId{DeleteCustomerSecondaryId: command},
 	}
-	data, err := proto.Marshal(req)
+	reply, err := s.sendCommand(ctx, req)
 	if err != nil {
 		return nil, err
 	}
-	res, err := s.client.RequestResponse(payload.New(data, []byte{})).Block(ctx)
-	if err != nil {
-		return nil, err
-	}
-	reply := new(hera.AppToServerCommandReply)
-	if err = proto.Unmarshal(res.Data(), reply); err != nil {
-		return nil, err
-	}
 	return &UpdateCustomerStateReply{
 		Status:      reply.GetUpdateCustomerState().Status,
 		Description: reply.GetUpdateCustomerState().Description,
@@ -765,18 +663,10 @@ func (s *elarian) LeaseCustomerAppData(ctx context.Context, customer IsCustomer)
 	req := &hera.AppToServerCommand{
 		Entry: &hera.AppToServerCommand_LeaseCustomerAppData{LeaseCustomerAppData: command},
 	}
-	data, err := proto.Marshal(req)
-	if err != nil {
-		return nil, err
-	}
-	res, err := s.client.RequestResponse(payload.New(data, []byte(""))).Block(ctx)
+	commandReply, err := s.sendCommand(ctx, req)
 	if err != nil {
 		return nil, err
 	}
-	commandReply := new(hera.AppToServerCommandReply)
-	if err = proto.Unmarshal(res.Data(), commandReply); err != nil {
-		return nil, err
-	}
 	reply := &LeaseCustomerAppDataReply{
 		Status:      commandReply.GetLeaseCustomerAppData().Status,
 		Description: commandReply.GetLeaseCustomerAppData().Description,
@@ -825,18 +715,10 @@ func (s *elarian) UpdateCustomerAppData(ctx context.Context, customer IsCustomer
 	req := &hera.AppToServerCommand{
 		Entry: &hera.AppToServerCommand_UpdateCustomerAppData{UpdateCustomerAppData: command},
 	}
-	data, err := proto.Marshal(req)
+	reply, err := s.sendCommand(ctx, req)
 	if err != nil {
 		return nil, err
 	}
-	res, err := s.client.RequestResponse(payload.New(data, []byte{})).Block(ctx)
-	if err != nil {
-		return nil, err
-	}
-	reply := new(hera.AppToServerCommandReply)
-	if err = proto.Unmarshal(res.Data(), reply); err != nil {
-		return nil, err
-	}
 	return &UpdateCustomerAppDataReply{
 		Status:      reply.GetUpdateCustomerAppData().Status,
 		Description: reply.GetUpdateCustomerAppData().Description,
@@ -864,18 +746,10 @@ func (s *elarian) DeleteCustomerAppData(ctx context.Context, customer IsCustomer
 	req := &hera.AppToServerCommand{
 		Entry: &hera.AppToServerCommand_DeleteCustomerAppData{DeleteCustomerAppData: command},
 	}
-	data, err := proto.Marshal(req)
-	if err != nil {
-		return nil, err
-	}
-	res, err := s.client.RequestResponse(payload.New(data, []byte{})).Block(ctx)
+	reply, err := s.sendCommand(ctx, req)
 	if err != nil {
 		return nil, err
 	}
-	reply := &hera.AppToServerCommandReply{}
-	if err = proto.Unmarshal(res.Data(), reply); err != nil {
-		return nil, err
-	}
 
 	return &UpdateCustomerAppDataReply{
 		Status:      reply.GetUpdateCustomerAppData().Status,
@@ -923,18 +797,10 @@ func (s *elarian) UpdateCustomerMetaData(ctx context.Context, customer IsCustome
 		Entry: &hera.AppToServerCommand_UpdateCustomerMetadata{UpdateCustomerMetadata: command},
 	}
 
-	data, err := proto.Marshal(req)
-	if err != nil {
-		return nil, err
-	}
-	res, err := s.client.RequestResponse(payload.New(data, []byte{})).Block(ctx)
+	reply, err := s.sendCommand(ctx, req)
 	if err != nil {
 		return nil, err
 	}
-	reply := &hera.AppToServerCommandReply{}
-	if err = proto.Unmarshal(res.Data(), reply); err != nil {
-		return nil, err
-	}
 	return &UpdateCustomerStateReply{
 		Status:      reply.GetUpdateCustomerState().Status,
 		Description: reply.GetUpdateCustomerState().Description,
@@ -966,18 +832,10 @@ func (s *elarian) DeleteCustomerMetaData(ctx context.Context, customer IsCustome
 		Entry: &hera.AppToServerCommand_DeleteCustomerMetadata{DeleteCustomerMetadata: command},
 	}
 
-	data, err := proto.Marshal(req)
-	if err != nil {
-		return nil, err
-	}
-	res, err := s.client.RequestResponse(payload.New(data, []byte{})).Block(ctx)
+	reply, err := s.sendCommand(ctx, req)
 	if err != nil {
 		return nil, err
 	}
-	reply := &hera.AppToServerCommandReply{}
-	if err = proto.Unmarshal(res.Data(), reply); err != nil {
-		return nil, err
-	}
 	return &UpdateCustomerStateReply{
 		Status:      reply.GetUpdateCustomerState().Status,
 		Description: reply.GetUpdateCustomerState().Description,
@@ -1005,18 +863,10 @@ func (s *elarian) UpdateMessagingConsent(ctx context.Context, customerNumber *Cu
 	req := &hera.AppToServerCommand{
 		Entry: &hera.AppToServerCommand_UpdateMessagingConsent{UpdateMessagingConsent: command},
 	}
-	data, err := proto.Marshal(req)
+	reply, err := s.sendCommand(ctx, req)
 	if err != nil {
 		return nil, err
 	}
-	res, err := s.client.RequestResponse(payload.New(data, []byte{})).Block(ctx)
-	if err != nil {
-		return nil, err
-	}
-	reply := &hera.AppToServerCommandReply{}
-	if err = proto.Unmarshal(res.Data(), reply); err != nil {
-		return nil, err
-	}
 	return &UpdateMessagingConsentReply{
 		Status:      MessagingConsentUpdateStatus(reply.GetUpdateMessagingConsent().Status),
 		Description: reply.GetUpdateMessagingConsent().Description,
@@ -0,0 +1,142 @@
+package elarian
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/rsocket/rsocket-go/core"
+)
+
+type (
+	// ConnectionError is returned when a connection to elarian cannot be established or is lost.
+	// Kind is one of ErrAuthFailed, ErrUnreachable or ErrSetupRejected and can be checked with errors.Is, Err is the underlying transport or rsocket error.
+	ConnectionError struct {
+		Kind error
+		Err  error
+	}
+
+	// CommandError is returned by commands that elarian replied to with a failed status when Options.StatusErrors is set.
+	// Code is the status elarian replied with, it is zero for commands whose status is a bool. Err is the sentinel error for the status,
+	// such as ErrMessageDeliveryNoConsent, and can be checked with errors.Is. Every CommandError also matches ErrCommandFailed
+	CommandError struct {
+		Command     string
+		Code        int32
+		Description string
+		CustomerID  string
+		Err         error
+	}
+
+	// HandlerError is sent on the error channel when a notification handler returns an error or panics.
+	// Panic is the value the handler panicked with and Stack the stack trace of the panic, both are empty when the handler returned Err.
+	HandlerError struct {
+		Notification Notification
+		CustomerID   string
+		Err          error
+		Panic        interface{}
+		Stack        []byte
+	}
+)
+
+// Connection errors
+var (
+	// ErrAuthFailed means elarian rejected the credentials in Options
+	ErrAuthFailed = errors.New("authentication failed")
+
+	// ErrUnreachable means elarian could not be reached or the connection to it was lost
+	ErrUnreachable = errors.New("elarian unreachable")
+
+	// ErrSetupRejected means elarian rejected the connection setup for a reason other than authentication
+	ErrSetupRejected = errors.New("connection setup rejected")
+)
+
+// ErrUnexpectedNotification is returned by the handlers registered with the typed On methods, such as OnReminder,
+// when they are published a notification of another type than the one they handle
+var ErrUnexpectedNotification = errors.New("unexpected notification")
+
+func (e *ConnectionError) Error() string {
+	return fmt.Sprintf("%v: %v", e.Kind, e.Err)
+}
+
+// Unwrap returns the underlying transport or rsocket error
+func (e *ConnectionError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is the kind of this connection error
+func (e *ConnectionError) Is(target error) bool {
+	return target == e.Kind
+}
+
+func (e *CommandError) Error() string {
+	if e.CustomerID == "" {
+		return fmt.Sprintf("%s command failed: %v: %s", e.Command, e.Err, e.Description)
+	}
+	return fmt.Sprintf("%s command failed for customer %q: %v: %s", e.Command, e.CustomerID, e.Err, e.Description)
+}
+
+// Unwrap returns the sentinel error for the status elarian replied with
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is ErrCommandFailed
+func (e *CommandError) Is(target error) bool {
+	return target == ErrCommandFailed
+}
+
+func (e *HandlerError) Error() string {
+	return fmt.Sprintf("notification %s handler for customer %q failed: %v", e.Notification, e.CustomerID, e.Err)
+}
+
+// Unwrap returns the error returned by the handler, or an error describing the panic
+func (e *HandlerError) Unwrap() error {
+	return e.Err
+}
+
+// unexpectedNotification returns the error a typed handler for want fails with when it is published notification
+func unexpectedNotification(want Notification, notification IsNotification) error {
+	return fmt.Errorf("%w: %s handler was published %T", ErrUnexpectedNotification, want, notification)
+}
+
+// newPanicError describes the value a handler panicked with as an error
+func newPanicError(value interface{}) error {
+	if err, ok := value.(error); ok {
+		return fmt.Errorf("panic: %w", err)
+	}
+	return fmt.Errorf("panic: %v", value)
+}
+
+// newConnectionError classifies an error returned by rsocket while connecting or raised when the connection closes
+func newConnectionError(err error) *ConnectionError {
+	var connErr *ConnectionError
+	if errors.As(err, &connErr) {
+		return connErr
+	}
+	var rsocketErr core.CustomError
+	if !errors.As(err, &rsocketErr) {
+		return &ConnectionError{Kind: ErrUnreachable, Err: err}
+	}
+	switch rsocketErr.ErrorCode() {
+	case core.ErrorCodeRejectedSetup, core.ErrorCodeRejectedResume:
+		if isAuthFailure(string(rsocketErr.ErrorData())) {
+			return &ConnectionError{Kind: ErrAuthFailed, Err: err}
+		}
+		return &ConnectionError{Kind: ErrSetupRejected, Err: err}
+	case core.ErrorCodeInvalidSetup, core.ErrorCodeUnsupportedSetup:
+		return &ConnectionError{Kind: ErrSetupRejected, Err: err}
+	default:
+		return &ConnectionError{Kind: ErrUnreachable, Err: err}
+	}
+}
+
+// isAuthFailure checks the reason sent by elarian with a rejected setup for authentication failures
+func isAuthFailure(reason string) bool {
+	reason = strings.ToLower(reason)
+	for _, keyword := range []string{"auth", "api key", "apikey", "token", "credential", "permission", "forbidden"} {
+		if strings.Contains(reason, keyword) {
+			return true
+		}
+	}
+	return false
+}
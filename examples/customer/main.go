@@ -59,11 +59,12 @@ func main() {
 		wg.Done()
 	}(wg)
 
-	service.On(elarian.ElarianReminderNotification, func(service elarian.Elarian, notf elarian.IsNotification, appData *elarian.Appdata, customer *elarian.Customer, cb elarian.NotificationCallBack) {
+	service.On(elarian.ElarianReminderNotification, func(ctx context.Context, service elarian.Elarian, notf elarian.IsNotification, appData *elarian.Appdata, customer *elarian.Customer, cb elarian.NotificationCallBack) error {
 		if notification, ok := notf.(*elarian.ReminderNotification); ok {
 			log.Println("NOTIFICATION_KEY", notification.Reminder.Key)
 			cb(nil, nil)
 		}
+		return nil
 	})
 
 	cust := service.NewCustomer(&elarian.CreateCustomer{ID: customerID})
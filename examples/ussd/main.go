@@ -350,22 +350,19 @@ func main() {
 		os.Exit(0)
 	}()
 
-	service.On(elarian.ElarianReminderNotification, func(service elarian.Elarian, notf elarian.IsNotification, appData *elarian.Appdata, customer *elarian.Customer, cb elarian.NotificationCallBack) {
-		if reminderNotification, ok := notf.(*elarian.ReminderNotification); ok {
-			processReminder(customer, reminderNotification)
-		}
+	service.OnReminder(func(ctx context.Context, service elarian.Elarian, notification *elarian.ReminderNotification, appData *elarian.Appdata, customer *elarian.Customer, cb elarian.NotificationCallBack) error {
+		processReminder(customer, notification)
+		return nil
 	})
 
-	service.On(elarian.ElarianReceivedUssdSessionNotification, func(service elarian.Elarian, notf elarian.IsNotification, appData *elarian.Appdata, customer *elarian.Customer, cb elarian.NotificationCallBack) {
-		if ussdNotification, ok := notf.(*elarian.UssdSessionNotification); ok {
-			processUssd(service, customer, ussdNotification, appData, cb)
-		}
+	service.OnReceivedUssdSession(func(ctx context.Context, service elarian.Elarian, notification *elarian.UssdSessionNotification, appData *elarian.Appdata, customer *elarian.Customer, cb elarian.NotificationCallBack) error {
+		processUssd(service, customer, notification, appData, cb)
+		return nil
 	})
 
-	service.On(elarian.ElarianReceivedPaymentNotification, func(service elarian.Elarian, notf elarian.IsNotification, appData *elarian.Appdata, customer *elarian.Customer, cb elarian.NotificationCallBack) {
-		if paymentNotification, ok := notf.(*elarian.ReceivedPaymentNotification); ok {
-			processPayment(customer, paymentNotification)
-		}
+	service.OnReceivedPayment(func(ctx context.Context, service elarian.Elarian, notification *elarian.ReceivedPaymentNotification, appData *elarian.Appdata, customer *elarian.Customer, cb elarian.NotificationCallBack) error {
+		processPayment(customer, notification)
+		return nil
 	})
 
 	wg := &sync.WaitGroup{}
@@ -0,0 +1,158 @@
+package elarian
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	hera "github.com/elarianltd/go-sdk/com_elarian_hera_proto"
+	"github.com/rsocket/rsocket-go/payload"
+	"github.com/rsocket/rsocket-go/rx/mono"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/protobuf/proto"
+)
+
+type (
+	// CommandInvoker sends a command to elarian and returns its reply
+	CommandInvoker func(ctx context.Context, command *hera.AppToServerCommand) (*hera.AppToServerCommandReply, error)
+
+	// CommandInterceptor is called with every command before it is sent, it calls invoker to send the command and sees the reply it returns.
+	// An interceptor can change the command or the reply, or return an error without calling invoker to stop the command from being sent.
+	CommandInterceptor func(ctx context.Context, command *hera.AppToServerCommand, invoker CommandInvoker) (*hera.AppToServerCommandReply, error)
+
+	// commandTransport sends an encoded command to elarian and returns its encoded reply, it is the connection outside of tests
+	commandTransport interface {
+		RequestResponse(message payload.Payload) mono.Mono
+	}
+
+	// commandExecutor sends every command the sdk issues. It encodes the command, sends it in a span, retries it according to the RetryPolicy, logs and measures it and decodes the reply.
+	// AppToServerCommands go through the interceptors first
+	commandExecutor struct {
+		mu           sync.RWMutex
+		interceptors []CommandInterceptor
+		transport    commandTransport
+		retrier      *retrier
+		logger       Logger
+		metrics      MetricsCollector
+		tracer       trace.Tracer
+		propagator   propagation.TextMapPropagator
+	}
+)
+
+func newCommandExecutor(logger Logger, metrics MetricsCollector, tracer trace.Tracer, propagator propagation.TextMapPropagator, retrier *retrier) *commandExecutor {
+	return &commandExecutor{logger: logger, metrics: metrics, tracer: tracer, propagator: propagator, retrier: retrier}
+}
+
+// sendCommand sends an AppToServerCommand and returns its reply, replies with a failed status are returned as a CommandError when Options.StatusErrors is set
+func (s *elarian) sendCommand(ctx context.Context, command *hera.AppToServerCommand) (*hera.AppToServerCommandReply, error) {
+	s.commands.mu.RLock()
+	interceptors := s.commands.interceptors
+	s.commands.mu.RUnlock()
+	reply, err := interceptCommand(interceptors, s.commands.invoke)(ctx, command)
+	if err != nil {
+		return nil, err
+	}
+	if s.statusErrors {
+		if err := statusError(entryName(command.ProtoReflect()), reply.ProtoReflect()); err != nil {
+			return nil, err
+		}
+	}
+	return reply, nil
+}
+
+// sendSimulatorCommand sends a SimulatorToServerCommand and returns its reply
+func (s *elarian) sendSimulatorCommand(ctx context.Context, command *hera.SimulatorToServerCommand) (*hera.SimulatorToServerCommandReply, error) {
+	reply := new(hera.SimulatorToServerCommandReply)
+	if err := s.commands.execute(ctx, "simulator.", command, reply); err != nil {
+		return nil, err
+	}
+	if s.statusErrors {
+		if err := statusError("simulator."+entryName(command.ProtoReflect()), reply.ProtoReflect()); err != nil {
+			return nil, err
+		}
+	}
+	return reply, nil
+}
+
+// invoke is the CommandInvoker the last interceptor calls
+func (e *commandExecutor) invoke(ctx context.Context, command *hera.AppToServerCommand) (*hera.AppToServerCommandReply, error) {
+	reply := new(hera.AppToServerCommandReply)
+	if err := e.execute(ctx, "", command, reply); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// use appends interceptors to the chain every AppToServerCommand is sent through
+func (e *commandExecutor) use(interceptors ...CommandInterceptor) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.interceptors = append(e.interceptors[:len(e.interceptors):len(e.interceptors)], interceptors...)
+}
+
+// interceptCommand wraps the invoker with the interceptors, the first interceptor is the outermost
+func interceptCommand(interceptors []CommandInterceptor, invoker CommandInvoker) CommandInvoker {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], invoker
+		invoker = func(ctx context.Context, command *hera.AppToServerCommand) (*hera.AppToServerCommandReply, error) {
+			return interceptor(ctx, command, next)
+		}
+	}
+	return invoker
+}
+
+func (s *elarian) UseCommandInterceptors(interceptors ...CommandInterceptor) {
+	s.commands.use(interceptors...)
+}
+
+// execute sends command and decodes its reply into reply, prefix is prepended to the command's name in its logs, metrics and span
+func (e *commandExecutor) execute(ctx context.Context, prefix string, command, reply proto.Message) (err error) {
+	name := prefix + entryName(command.ProtoReflect())
+	attributes := append(customerAttributes(command.ProtoReflect()), attributeCommand.String(name))
+	ctx, span := e.tracer.Start(ctx, "elarian command "+name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attributes...))
+	started := time.Now()
+	defer func() {
+		duration := time.Since(started)
+		e.metrics.CommandSent(name, duration, err)
+		if err != nil {
+			e.logger.Warn("command failed", "command", name, "duration", duration, "error", err)
+		} else {
+			e.logger.Debug("received reply", "command", name, "duration", duration)
+		}
+		endSpan(span, err)
+	}()
+
+	data, err := proto.Marshal(command)
+	if err != nil {
+		return fmt.Errorf("encoding %s command: %w", name, err)
+	}
+	if e.transport == nil {
+		return &ConnectionError{Kind: ErrUnreachable, Err: errNotConnected}
+	}
+	message := payload.New(data, injectMetadata(ctx, e.propagator))
+	var res payload.Payload
+	for attempt := 1; ; attempt++ {
+		e.logger.Debug("sending command", "command", name, "attempt", attempt)
+		res, err = e.transport.RequestResponse(message).Block(ctx)
+		if err == nil || !e.retrier.retry(name, attempt, err) {
+			break
+		}
+		delay := e.retrier.delay(attempt)
+		e.logger.Warn("retrying command", "command", name, "attempt", attempt, "delay", delay, "error", err)
+		span.AddEvent("retry", trace.WithAttributes(attributeAttempt.Int(attempt), attributeError.String(err.Error())))
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if err := proto.Unmarshal(res.Data(), reply); err != nil {
+		return fmt.Errorf("decoding %s reply: %w", name, err)
+	}
+	return nil
+}
@@ -3,11 +3,13 @@ module github.com/elarianltd/go-sdk
 go 1.15
 
 require (
-	github.com/asaskevich/EventBus v0.0.0-20200907212545-49d423059eef
 	github.com/golang/protobuf v1.5.1
 	github.com/google/uuid v1.2.0 // indirect
 	github.com/rsocket/rsocket-go v0.8.2
 	github.com/stretchr/testify v1.7.0
+	go.opentelemetry.io/otel v1.0.0
+	go.opentelemetry.io/otel/sdk v1.0.0
+	go.opentelemetry.io/otel/trace v1.0.0
 	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1 // indirect
 	google.golang.org/protobuf v1.26.0
 )
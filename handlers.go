@@ -0,0 +1,307 @@
+package elarian
+
+import (
+	"context"
+)
+
+type (
+	// ReminderHandler handles the ElarianReminderNotification
+	ReminderHandler func(ctx context.Context, service Elarian, notification *ReminderNotification, appData *Appdata, customer *Customer, cb NotificationCallBack) error
+
+	// MessageStatusHandler handles the ElarianMessageStatusNotification
+	MessageStatusHandler func(ctx context.Context, service Elarian, notification *MessageStatusNotification, appData *Appdata, customer *Customer, cb NotificationCallBack) error
+
+	// MessagingSessionStartedHandler handles the ElarianMessagingSessionStartedNotification
+	MessagingSessionStartedHandler func(ctx context.Context, service Elarian, notification *MessageSessionStartedNotification, appData *Appdata, customer *Customer, cb NotificationCallBack) error
+
+	// MessagingSessionRenewedHandler handles the ElarianMessagingSessionRenewedNotification
+	MessagingSessionRenewedHandler func(ctx context.Context, service Elarian, notification *MessageSessionRenewedNotification, appData *Appdata, customer *Customer, cb NotificationCallBack) error
+
+	// MessagingSessionEndedHandler handles the ElarianMessagingSessionEndedNotification
+	MessagingSessionEndedHandler func(ctx context.Context, service Elarian, notification *MessageSessionEndedNotification, appData *Appdata, customer *Customer, cb NotificationCallBack) error
+
+	// MessagingConsentUpdateHandler handles the ElarianMessagingConsentUpdateNotification
+	MessagingConsentUpdateHandler func(ctx context.Context, service Elarian, notification *MessagingConsentUpdateNotification, appData *Appdata, customer *Customer, cb NotificationCallBack) error
+
+	// ReceivedEmailHandler handles the ElarianReceivedEmailNotification
+	ReceivedEmailHandler func(ctx context.Context, service Elarian, notification *Email, appData *Appdata, customer *Customer, cb NotificationCallBack) error
+
+	// ReceivedUssdSessionHandler handles the ElarianReceivedUssdSessionNotification
+	ReceivedUssdSessionHandler func(ctx context.Context, service Elarian, notification *UssdSessionNotification, appData *Appdata, customer *Customer, cb NotificationCallBack) error
+
+	// ReceivedVoiceCallHandler handles the ElarianReceivedVoiceCallNotification
+	ReceivedVoiceCallHandler func(ctx context.Context, service Elarian, notification *Voice, appData *Appdata, customer *Customer, cb NotificationCallBack) error
+
+	// ReceivedSmsHandler handles the ElarianReceivedSmsNotification
+	ReceivedSmsHandler func(ctx context.Context, service Elarian, notification *InBoundMessageBody, appData *Appdata, customer *Customer, cb NotificationCallBack) error
+
+	// ReceivedFbMessengerHandler handles the ElarianReceivedFbMessengerNotification
+	ReceivedFbMessengerHandler func(ctx context.Context, service Elarian, notification *InBoundMessageBody, appData *Appdata, customer *Customer, cb NotificationCallBack) error
+
+	// ReceivedTelegramHandler handles the ElarianReceivedTelegramNotification
+	ReceivedTelegramHandler func(ctx context.Context, service Elarian, notification *InBoundMessageBody, appData *Appdata, customer *Customer, cb NotificationCallBack) error
+
+	// ReceivedWhatsappHandler handles the ElarianReceivedWhatsappNotification
+	ReceivedWhatsappHandler func(ctx context.Context, service Elarian, notification *InBoundMessageBody, appData *Appdata, customer *Customer, cb NotificationCallBack) error
+
+	// ReceivedMessageHandler handles the ElarianReceivedMessageNotification
+	ReceivedMessageHandler func(ctx context.Context, service Elarian, notification *RecievedMessageNotification, appData *Appdata, customer *Customer, cb NotificationCallBack) error
+
+	// SentMessageReactionHandler handles the ElarianSentMessageReactionNotification
+	SentMessageReactionHandler func(ctx context.Context, service Elarian, notification *SentMessageReaction, appData *Appdata, customer *Customer, cb NotificationCallBack) error
+
+	// ReceivedPaymentHandler handles the ElarianReceivedPaymentNotification
+	ReceivedPaymentHandler func(ctx context.Context, service Elarian, notification *ReceivedPaymentNotification, appData *Appdata, customer *Customer, cb NotificationCallBack) error
+
+	// PaymentStatusHandler handles the ElarianPaymentStatusNotification
+	PaymentStatusHandler func(ctx context.Context, service Elarian, notification *PaymentStatusNotification, appData *Appdata, customer *Customer, cb NotificationCallBack) error
+
+	// WalletPaymentStatusHandler handles the ElarianWalletPaymentStatusNotification
+	WalletPaymentStatusHandler func(ctx context.Context, service Elarian, notification *WalletPaymentStatusNotification, appData *Appdata, customer *Customer, cb NotificationCallBack) error
+
+	// CustomerActivityHandler handles the ElarianCustomerActivityNotification
+	CustomerActivityHandler func(ctx context.Context, service Elarian, notification *CustomerActivityNotification, appData *Appdata, customer *Customer, cb NotificationCallBack) error
+
+	// PaymentPurseHandler handles the ElarianPaymentPurseNotifiication
+	PaymentPurseHandler func(ctx context.Context, service Elarian, notification *PurseNotification, appData *Appdata, customer *Customer, cb NotificationCallBack) error
+
+	// SendChannelPaymentSimulatorHandler handles the ElarianSendChannelPaymentSimulatorNotification
+	SendChannelPaymentSimulatorHandler func(ctx context.Context, service Elarian, notification *SendChannelPaymentSimulatorNotification, appData *Appdata, customer *Customer, cb NotificationCallBack) error
+
+	// CheckoutPaymentSimulatorHandler handles the ElarianCheckoutPaymentSimulatorNotification
+	CheckoutPaymentSimulatorHandler func(ctx context.Context, service Elarian, notification *CheckoutPaymentSimulatorNotification, appData *Appdata, customer *Customer, cb NotificationCallBack) error
+
+	// SendCustomerPaymentSimulatorHandler handles the ElarianSendCustomerPaymentSimulatorNotification
+	SendCustomerPaymentSimulatorHandler func(ctx context.Context, service Elarian, notification *SendCustomerPaymentSimulatorNotification, appData *Appdata, customer *Customer, cb NotificationCallBack) error
+
+	// MakeVoiceCallSimulatorHandler handles the ElarianMakeVoiceCallSimulatorNotification
+	MakeVoiceCallSimulatorHandler func(ctx context.Context, service Elarian, notification *MakeVoiceCallSimulatorNotification, appData *Appdata, customer *Customer, cb NotificationCallBack) error
+
+	// SendMessageSimulatorHandler handles the ElarianSendMessageSimulatorNotification
+	SendMessageSimulatorHandler func(ctx context.Context, service Elarian, notification *SendMessageSimulatorNotification, appData *Appdata, customer *Customer, cb NotificationCallBack) error
+)
+
+func (s *elarian) OnReminder(handler ReminderHandler) *Subscription {
+	return s.On(ElarianReminderNotification, func(ctx context.Context, service Elarian, notification IsNotification, appData *Appdata, customer *Customer, cb NotificationCallBack) error {
+		if notf, ok := notification.(*ReminderNotification); ok {
+			return handler(ctx, service, notf, appData, customer, cb)
+		}
+		return unexpectedNotification(ElarianReminderNotification, notification)
+	})
+}
+
+func (s *elarian) OnMessageStatus(handler MessageStatusHandler) *Subscription {
+	return s.On(ElarianMessageStatusNotification, func(ctx context.Context, service Elarian, notification IsNotification, appData *Appdata, customer *Customer, cb NotificationCallBack) error {
+		if notf, ok := notification.(*MessageStatusNotification); ok {
+			return handler(ctx, service, notf, appData, customer, cb)
+		}
+		return unexpectedNotification(ElarianMessageStatusNotification, notification)
+	})
+}
+
+func (s *elarian) OnMessagingSessionStarted(handler MessagingSessionStartedHandler) *Subscription {
+	return s.On(ElarianMessagingSessionStartedNotification, func(ctx context.Context, service Elarian, notification IsNotification, appData *Appdata, customer *Customer, cb NotificationCallBack) error {
+		if notf, ok := notification.(*MessageSessionStartedNotification); ok {
+			return handler(ctx, service, notf, appData, customer, cb)
+		}
+		return unexpectedNotification(ElarianMessagingSessionStartedNotification, notification)
+	})
+}
+
+func (s *elarian) OnMessagingSessionRenewed(handler MessagingSessionRenewedHandler) *Subscription {
+	return s.On(ElarianMessagingSessionRenewedNotification, func(ctx context.Context, service Elarian, notification IsNotification, appData *Appdata, customer *Customer, cb NotificationCallBack) error {
+		if notf, ok := notification.(*MessageSessionRenewedNotification); ok {
+			return handler(ctx, service, notf, appData, customer, cb)
+		}
+		return unexpectedNotification(ElarianMessagingSessionRenewedNotification, notification)
+	})
+}
+
+func (s *elarian) OnMessagingSessionEnded(handler MessagingSessionEndedHandler) *Subscription {
+	return s.On(ElarianMessagingSessionEndedNotification, func(ctx context.Context, service Elarian, notification IsNotification, appData *Appdata, customer *Customer, cb NotificationCallBack) error {
+		if notf, ok := notification.(*MessageSessionEndedNotification); ok {
+			return handler(ctx, service, notf, appData, customer, cb)
+		}
+		return unexpectedNotification(ElarianMessagingSessionEndedNotification, notification)
+	})
+}
+
+func (s *elarian) OnMessagingConsentUpdate(handler MessagingConsentUpdateHandler) *Subscription {
+	return s.On(ElarianMessagingConsentUpdateNotification, func(ctx context.Context, service Elarian, notification IsNotification, appData *Appdata, customer *Customer, cb NotificationCallBack) error {
+		if notf, ok := notification.(*MessagingConsentUpdateNotification); ok {
+			return handler(ctx, service, notf, appData, customer, cb)
+		}
+		return unexpectedNotification(ElarianMessagingConsentUpdateNotification, notification)
+	})
+}
+
+func (s *elarian) OnReceivedEmail(handler ReceivedEmailHandler) *Subscription {
+	return s.On(ElarianReceivedEmailNotification, func(ctx context.Context, service Elarian, notification IsNotification, appData *Appdata, customer *Customer, cb NotificationCallBack) error {
+		if notf, ok := notification.(*Email); ok {
+			return handler(ctx, service, notf, appData, customer, cb)
+		}
+		return unexpectedNotification(ElarianReceivedEmailNotification, notification)
+	})
+}
+
+func (s *elarian) OnReceivedUssdSession(handler ReceivedUssdSessionHandler) *Subscription {
+	return s.On(ElarianReceivedUssdSessionNotification, func(ctx context.Context, service Elarian, notification IsNotification, appData *Appdata, customer *Customer, cb NotificationCallBack) error {
+		if notf, ok := notification.(*UssdSessionNotification); ok {
+			return handler(ctx, service, notf, appData, customer, cb)
+		}
+		return unexpectedNotification(ElarianReceivedUssdSessionNotification, notification)
+	})
+}
+
+func (s *elarian) OnReceivedVoiceCall(handler ReceivedVoiceCallHandler) *Subscription {
+	return s.On(ElarianReceivedVoiceCallNotification, func(ctx context.Context, service Elarian, notification IsNotification, appData *Appdata, customer *Customer, cb NotificationCallBack) error {
+		if notf, ok := notification.(*Voice); ok {
+			return handler(ctx, service, notf, appData, customer, cb)
+		}
+		return unexpectedNotification(ElarianReceivedVoiceCallNotification, notification)
+	})
+}
+
+func (s *elarian) OnReceivedSms(handler ReceivedSmsHandler) *Subscription {
+	return s.On(ElarianReceivedSmsNotification, func(ctx context.Context, service Elarian, notification IsNotification, appData *Appdata, customer *Customer, cb NotificationCallBack) error {
+		if notf, ok := notification.(*InBoundMessageBody); ok {
+			return handler(ctx, service, notf, appData, customer, cb)
+		}
+		return unexpectedNotification(ElarianReceivedSmsNotification, notification)
+	})
+}
+
+func (s *elarian) OnReceivedFbMessenger(handler ReceivedFbMessengerHandler) *Subscription {
+	return s.On(ElarianReceivedFbMessengerNotification, func(ctx context.Context, service Elarian, notification IsNotification, appData *Appdata, customer *Customer, cb NotificationCallBack) error {
+		if notf, ok := notification.(*InBoundMessageBody); ok {
+			return handler(ctx, service, notf, appData, customer, cb)
+		}
+		return unexpectedNotification(ElarianReceivedFbMessengerNotification, notification)
+	})
+}
+
+func (s *elarian) OnReceivedTelegram(handler ReceivedTelegramHandler) *Subscription {
+	return s.On(ElarianReceivedTelegramNotification, func(ctx context.Context, service Elarian, notification IsNotification, appData *Appdata, customer *Customer, cb NotificationCallBack) error {
+		if notf, ok := notification.(*InBoundMessageBody); ok {
+			return handler(ctx, service, notf, appData, customer, cb)
+		}
+		return unexpectedNotification(ElarianReceivedTelegramNotification, notification)
+	})
+}
+
+func (s *elarian) OnReceivedWhatsapp(handler ReceivedWhatsappHandler) *Subscription {
+	return s.On(ElarianReceivedWhatsappNotification, func(ctx context.Context, service Elarian, notification IsNotification, appData *Appdata, customer *Customer, cb NotificationCallBack) error {
+		if notf, ok := notification.(*InBoundMessageBody); ok {
+			return handler(ctx, service, notf, appData, customer, cb)
+		}
+		return unexpectedNotification(ElarianReceivedWhatsappNotification, notification)
+	})
+}
+
+func (s *elarian) OnSentMessageReaction(handler SentMessageReactionHandler) *Subscription {
+	return s.On(ElarianSentMessageReactionNotification, func(ctx context.Context, service Elarian, notification IsNotification, appData *Appdata, customer *Customer, cb NotificationCallBack) error {
+		if notf, ok := notification.(*SentMessageReaction); ok {
+			return handler(ctx, service, notf, appData, customer, cb)
+		}
+		return unexpectedNotification(ElarianSentMessageReactionNotification, notification)
+	})
+}
+
+func (s *elarian) OnReceivedPayment(handler ReceivedPaymentHandler) *Subscription {
+	return s.On(ElarianReceivedPaymentNotification, func(ctx context.Context, service Elarian, notification IsNotification, appData *Appdata, customer *Customer, cb NotificationCallBack) error {
+		if notf, ok := notification.(*ReceivedPaymentNotification); ok {
+			return handler(ctx, service, notf, appData, customer, cb)
+		}
+		return unexpectedNotification(ElarianReceivedPaymentNotification, notification)
+	})
+}
+
+func (s *elarian) OnPaymentStatus(handler PaymentStatusHandler) *Subscription {
+	return s.On(ElarianPaymentStatusNotification, func(ctx context.Context, service Elarian, notification IsNotification, appData *Appdata, customer *Customer, cb NotificationCallBack) error {
+		if notf, ok := notification.(*PaymentStatusNotification); ok {
+			return handler(ctx, service, notf, appData, customer, cb)
+		}
+		return unexpectedNotification(ElarianPaymentStatusNotification, notification)
+	})
+}
+
+func (s *elarian) OnWalletPaymentStatus(handler WalletPaymentStatusHandler) *Subscription {
+	return s.On(ElarianWalletPaymentStatusNotification, func(ctx context.Context, service Elarian, notification IsNotification, appData *Appdata, customer *Customer, cb NotificationCallBack) error {
+		if notf, ok := notification.(*WalletPaymentStatusNotification); ok {
+			return handler(ctx, service, notf, appData, customer, cb)
+		}
+		return unexpectedNotification(ElarianWalletPaymentStatusNotification, notification)
+	})
+}
+
+func (s *elarian) OnCustomerActivity(handler CustomerActivityHandler) *Subscription {
+	return s.On(ElarianCustomerActivityNotification, func(ctx context.Context, service Elarian, notification IsNotification, appData *Appdata, customer *Customer, cb NotificationCallBack) error {
+		if notf, ok := notification.(*CustomerActivityNotification); ok {
+			return handler(ctx, service, notf, appData, customer, cb)
+		}
+		return unexpectedNotification(ElarianCustomerActivityNotification, notification)
+	})
+}
+
+func (s *elarian) OnPaymentPurse(handler PaymentPurseHandler) *Subscription {
+	return s.On(ElarianPaymentPurseNotifiication, func(ctx context.Context, service Elarian, notification IsNotification, appData *Appdata, customer *Customer, cb NotificationCallBack) error {
+		if notf, ok := notification.(*PurseNotification); ok {
+			return handler(ctx, service, notf, appData, customer, cb)
+		}
+		return unexpectedNotification(ElarianPaymentPurseNotifiication, notification)
+	})
+}
+
+func (s *elarian) OnSendChannelPaymentSimulator(handler SendChannelPaymentSimulatorHandler) *Subscription {
+	return s.On(ElarianSendChannelPaymentSimulatorNotification, func(ctx context.Context, service Elarian, notification IsNotification, appData *Appdata, customer *Customer, cb NotificationCallBack) error {
+		if notf, ok := notification.(*SendChannelPaymentSimulatorNotification); ok {
+			return handler(ctx, service, notf, appData, customer, cb)
+		}
+		return unexpectedNotification(ElarianSendChannelPaymentSimulatorNotification, notification)
+	})
+}
+
+func (s *elarian) OnCheckoutPaymentSimulator(handler CheckoutPaymentSimulatorHandler) *Subscription {
+	return s.On(ElarianCheckoutPaymentSimulatorNotification, func(ctx context.Context, service Elarian, notification IsNotification, appData *Appdata, customer *Customer, cb NotificationCallBack) error {
+		if notf, ok := notification.(*CheckoutPaymentSimulatorNotification); ok {
+			return handler(ctx, service, notf, appData, customer, cb)
+		}
+		return unexpectedNotification(ElarianCheckoutPaymentSimulatorNotification, notification)
+	})
+}
+
+func (s *elarian) OnSendCustomerPaymentSimulator(handler SendCustomerPaymentSimulatorHandler) *Subscription {
+	return s.On(ElarianSendCustomerPaymentSimulatorNotification, func(ctx context.Context, service Elarian, notification IsNotification, appData *Appdata, customer *Customer, cb NotificationCallBack) error {
+		if notf, ok := notification.(*SendCustomerPaymentSimulatorNotification); ok {
+			return handler(ctx, service, notf, appData, customer, cb)
+		}
+		return unexpectedNotification(ElarianSendCustomerPaymentSimulatorNotification, notification)
+	})
+}
+
+func (s *elarian) OnMakeVoiceCallSimulator(handler MakeVoiceCallSimulatorHandler) *Subscription {
+	return s.On(ElarianMakeVoiceCallSimulatorNotification, func(ctx context.Context, service Elarian, notification IsNotification, appData *Appdata, customer *Customer, cb NotificationCallBack) error {
+		if notf, ok := notification.(*MakeVoiceCallSimulatorNotification); ok {
+			return handler(ctx, service, notf, appData, customer, cb)
+		}
+		return unexpectedNotification(ElarianMakeVoiceCallSimulatorNotification, notification)
+	})
+}
+
+func (s *elarian) OnSendMessageSimulator(handler SendMessageSimulatorHandler) *Subscription {
+	return s.On(ElarianSendMessageSimulatorNotification, func(ctx context.Context, service Elarian, notification IsNotification, appData *Appdata, customer *Customer, cb NotificationCallBack) error {
+		if notf, ok := notification.(*SendMessageSimulatorNotification); ok {
+			return handler(ctx, service, notf, appData, customer, cb)
+		}
+		return unexpectedNotification(ElarianSendMessageSimulatorNotification, notification)
+	})
+}
+
+func (s *elarian) OnReceivedMessage(handler ReceivedMessageHandler) *Subscription {
+	return s.On(ElarianReceivedMessageNotification, func(ctx context.Context, service Elarian, notification IsNotification, appData *Appdata, customer *Customer, cb NotificationCallBack) error {
+		if notf, ok := notification.(*RecievedMessageNotification); ok {
+			return handler(ctx, service, notf, appData, customer, cb)
+		}
+		return unexpectedNotification(ElarianReceivedMessageNotification, notification)
+	})
+}
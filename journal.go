@@ -0,0 +1,254 @@
+package elarian
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	hera "github.com/elarianltd/go-sdk/com_elarian_hera_proto"
+	"google.golang.org/protobuf/proto"
+)
+
+type (
+	// journal is an append-only log of the notifications received from elarian.
+	// Every notification is appended before it is dispatched and marked done once its handlers reply to it, or once the default reply was sent and its handlers returned.
+	// Notifications that are rejected or overflow their queue are marked done straight away, the notifications that were never marked done are replayed
+	// to the handlers the next time the service starts. The records of the pending notifications are kept so that the journal can be compacted.
+	journal struct {
+		mu      sync.Mutex
+		path    string
+		file    *os.File
+		next    uint64
+		pending map[uint64]string
+		records int
+	}
+
+	// journalEntry is a notification that was appended to the journal but not marked done
+	journalEntry struct {
+		id           uint64
+		notification *hera.ServerToAppNotification
+	}
+)
+
+const (
+	journalAppended string = "+"
+	journalDone     string = "-"
+
+	// journalCompactSize is the number of records the journal holds before it is rewritten with only the pending notifications,
+	// it is rewritten once it holds twice as many records as there are pending notifications when more are pending
+	journalCompactSize int = 1000
+)
+
+// openJournal opens or creates the journal at path and returns the notifications in it that were not marked done, in the order they were received.
+// Records that cannot be read, such as one cut short by a crash, are skipped.
+func openJournal(path string) (*journal, []*journalEntry, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, nil, fmt.Errorf("opening journal: %w", err)
+	}
+	j := &journal{path: path, file: file, pending: make(map[uint64]string)}
+	entries := make(map[uint64]*journalEntry)
+	var order []uint64
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		id, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		if id >= j.next {
+			j.next = id + 1
+		}
+		j.records++
+		switch {
+		case fields[0] == journalAppended && len(fields) == 3:
+			data, err := base64.StdEncoding.DecodeString(fields[2])
+			if err != nil {
+				continue
+			}
+			notification := new(hera.ServerToAppNotification)
+			if err := proto.Unmarshal(data, notification); err != nil {
+				continue
+			}
+			entries[id] = &journalEntry{id: id, notification: notification}
+			j.pending[id] = line + "\n"
+			order = append(order, id)
+		case fields[0] == journalDone:
+			delete(entries, id)
+			delete(j.pending, id)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return nil, nil, fmt.Errorf("reading journal: %w", err)
+	}
+
+	var pending []*journalEntry
+	for _, id := range order {
+		if entry, ok := entries[id]; ok {
+			pending = append(pending, entry)
+		}
+	}
+	return j, pending, nil
+}
+
+// append writes the notification to the journal and returns the id it is marked done with
+func (j *journal) append(notification *hera.ServerToAppNotification) (uint64, error) {
+	data, err := proto.Marshal(notification)
+	if err != nil {
+		return 0, fmt.Errorf("journaling notification: %w", err)
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	id := j.next
+	j.next++
+	record := fmt.Sprintf("%s %d %s\n", journalAppended, id, base64.StdEncoding.EncodeToString(data))
+	if err := j.write(record); err != nil {
+		return 0, err
+	}
+	j.pending[id] = record
+	return id, nil
+}
+
+// done marks a notification as handled, the journal is compacted once it is large
+func (j *journal) done(id uint64) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err := j.write(fmt.Sprintf("%s %d\n", journalDone, id)); err != nil {
+		return err
+	}
+	delete(j.pending, id)
+	if j.records < journalCompactSize || j.records < 2*len(j.pending) {
+		return nil
+	}
+	return j.compact()
+}
+
+// compact rewrites the journal with only the records of the pending notifications, in the order they were received
+func (j *journal) compact() error {
+	ids := make([]uint64, 0, len(j.pending))
+	for id := range j.pending {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(a, b int) bool { return ids[a] < ids[b] })
+
+	tmp, err := ioutil.TempFile(filepath.Dir(j.path), filepath.Base(j.path)+".*")
+	if err != nil {
+		return fmt.Errorf("compacting journal: %w", err)
+	}
+	writer := bufio.NewWriter(tmp)
+	for _, id := range ids {
+		writer.WriteString(j.pending[id])
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("compacting journal: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("compacting journal: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), j.path); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("compacting journal: %w", err)
+	}
+	j.file.Close()
+	j.file, j.records = tmp, len(ids)
+	return nil
+}
+
+func (j *journal) write(record string) error {
+	if j.file == nil {
+		return os.ErrClosed
+	}
+	if _, err := j.file.WriteString(record); err != nil {
+		return fmt.Errorf("writing journal: %w", err)
+	}
+	if err := j.file.Sync(); err != nil {
+		return fmt.Errorf("syncing journal: %w", err)
+	}
+	j.records++
+	return nil
+}
+
+func (j *journal) close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.file == nil {
+		return nil
+	}
+	err := j.file.Close()
+	j.file = nil
+	return err
+}
+
+// completed returns the function that marks a notification done, it is called once the notification is completed.
+// Errors are reported, notifications completed after the journal is closed are replayed the next time the service starts
+func (j *journal) completed(id uint64, reportError func(err error)) func() {
+	return func() {
+		if err := j.done(id); err != nil && !errors.Is(err, os.ErrClosed) {
+			reportError(err)
+		}
+	}
+}
+
+// journaled appends the notification to the journal and marks it done once it is completed.
+// Errors are reported and the notification is dispatched anyway
+func (s *service) journaled(req *notificationRequest) {
+	if s.journal == nil {
+		return
+	}
+	id, err := s.journal.append(req.notification)
+	if err != nil {
+		s.reportError(err)
+		return
+	}
+	req.onComplete = s.journal.completed(id, s.reportError)
+}
+
+// replay dispatches the notifications that were not marked done when the service last stopped.
+// Elarian is no longer waiting for their replies so the replies are discarded, the notifications are marked done once they are completed.
+func (s *elarian) replay(entries []*journalEntry) {
+	for _, entry := range entries {
+		if !s.gate.enter(1) {
+			return
+		}
+		req := newNotificationRequest(entry.notification)
+		req.replayed = true
+		req.onComplete = s.journal.completed(entry.id, s.reportError)
+		if err := s.pool.enqueue(s.gate.ctx, &notificationJob{key: notificationKey(entry.notification), request: req}); err != nil {
+			s.gate.leave()
+			return
+		}
+	}
+}
+
+// closeFiles closes the journal and the recording, notifications completed afterwards are replayed the next time the service starts
+func (s *elarian) closeFiles() {
+	if s.journal != nil {
+		if err := s.journal.close(); err != nil {
+			s.reportError(fmt.Errorf("closing journal: %w", err))
+		}
+	}
+	if s.recorder != nil {
+		if err := s.recorder.close(); err != nil {
+			s.reportError(fmt.Errorf("closing recording: %w", err))
+		}
+	}
+}
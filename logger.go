@@ -0,0 +1,207 @@
+package elarian
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+type (
+	// Logger receives the sdk's structured logs. keyvals are alternating keys and values, keys are strings.
+	// Implementations must be safe to call from multiple goroutines.
+	Logger interface {
+		Debug(msg string, keyvals ...interface{})
+		Info(msg string, keyvals ...interface{})
+		Warn(msg string, keyvals ...interface{})
+		Error(msg string, keyvals ...interface{})
+	}
+
+	// LogLevel is the severity of a log
+	LogLevel int32
+
+	// stdLogger writes logs at or above its level to a standard logger as key=value pairs
+	stdLogger struct {
+		out   *log.Logger
+		level LogLevel
+	}
+
+	// nopLogger discards every log
+	nopLogger struct{}
+
+	// redactingLogger hides the credentials the service was created with, and the values of keys that hold credentials, before logs reach the next logger
+	redactingLogger struct {
+		next    Logger
+		secrets []string
+	}
+)
+
+// LogLevel constants
+const (
+	LogLevelDebug LogLevel = iota
+	LogLevelInfo
+	LogLevelWarn
+	LogLevelError
+)
+
+const redacted string = "[REDACTED]"
+
+func (l LogLevel) String() string {
+	switch l {
+	case LogLevelDebug:
+		return "debug"
+	case LogLevelInfo:
+		return "info"
+	case LogLevelWarn:
+		return "warn"
+	case LogLevelError:
+		return "error"
+	default:
+		return "unknown"
+	}
+}
+
+// NewStdLogger returns a Logger that writes the logs at or above level to out as key=value pairs, if out is nil the standard logger is used
+func NewStdLogger(out *log.Logger, level LogLevel) Logger {
+	if out == nil {
+		out = log.New(os.Stderr, "", log.LstdFlags)
+	}
+	return &stdLogger{out: out, level: level}
+}
+
+func (l *stdLogger) Debug(msg string, keyvals ...interface{}) { l.log(LogLevelDebug, msg, keyvals) }
+func (l *stdLogger) Info(msg string, keyvals ...interface{})  { l.log(LogLevelInfo, msg, keyvals) }
+func (l *stdLogger) Warn(msg string, keyvals ...interface{})  { l.log(LogLevelWarn, msg, keyvals) }
+func (l *stdLogger) Error(msg string, keyvals ...interface{}) { l.log(LogLevelError, msg, keyvals) }
+
+func (l *stdLogger) log(level LogLevel, msg string, keyvals []interface{}) {
+	if level < l.level {
+		return
+	}
+	var line strings.Builder
+	fmt.Fprintf(&line, "level=%s msg=%q", level, msg)
+	for i := 0; i < len(keyvals); i += 2 {
+		key, value := fmt.Sprint(keyvals[i]), interface{}("")
+		if i+1 < len(keyvals) {
+			value = keyvals[i+1]
+		}
+		switch v := value.(type) {
+		case string:
+			fmt.Fprintf(&line, " %s=%q", key, v)
+		case error:
+			fmt.Fprintf(&line, " %s=%q", key, v.Error())
+		case fmt.Stringer:
+			fmt.Fprintf(&line, " %s=%q", key, v.String())
+		default:
+			fmt.Fprintf(&line, " %s=%v", key, v)
+		}
+	}
+	l.out.Println(line.String())
+}
+
+func (nopLogger) Debug(msg string, keyvals ...interface{}) {}
+func (nopLogger) Info(msg string, keyvals ...interface{})  {}
+func (nopLogger) Warn(msg string, keyvals ...interface{})  {}
+func (nopLogger) Error(msg string, keyvals ...interface{}) {}
+
+// newLogger returns the logger the service logs to. It is Options.Logger, or a standard logger at the info level when only Options.Log is set,
+// wrapped so that the API key and auth token never reach it
+func newLogger(options *Options) Logger {
+	logger := options.Logger
+	if logger == nil && options.Log {
+		logger = NewStdLogger(nil, LogLevelInfo)
+	}
+	if logger == nil {
+		return nopLogger{}
+	}
+	return &redactingLogger{next: logger, secrets: credentials(options)}
+}
+
+// credentials returns the api key and auth token the service is created with, they are redacted from every log
+func credentials(options *Options) []string {
+	var secrets []string
+	for _, secret := range []string{options.APIKey, options.AuthToken} {
+		if secret != "" {
+			secrets = append(secrets, secret)
+		}
+	}
+	return secrets
+}
+
+// redacting returns logger wrapped so that the service's credentials never reach it, the service's own logger is returned when logger is nil
+func (s *elarian) redacting(logger Logger) Logger {
+	if logger == nil {
+		return s.logger
+	}
+	return &redactingLogger{next: logger, secrets: s.secrets}
+}
+
+func (l *redactingLogger) Debug(msg string, keyvals ...interface{}) {
+	l.next.Debug(l.redact(msg), l.redactAll(keyvals)...)
+}
+
+func (l *redactingLogger) Info(msg string, keyvals ...interface{}) {
+	l.next.Info(l.redact(msg), l.redactAll(keyvals)...)
+}
+
+func (l *redactingLogger) Warn(msg string, keyvals ...interface{}) {
+	l.next.Warn(l.redact(msg), l.redactAll(keyvals)...)
+}
+
+func (l *redactingLogger) Error(msg string, keyvals ...interface{}) {
+	l.next.Error(l.redact(msg), l.redactAll(keyvals)...)
+}
+
+// redactAll returns a copy of keyvals where the values of credential keys are replaced and the credentials are removed from other values
+func (l *redactingLogger) redactAll(keyvals []interface{}) []interface{} {
+	out := make([]interface{}, len(keyvals))
+	for i := 0; i < len(keyvals); i += 2 {
+		out[i] = keyvals[i]
+		if i+1 == len(keyvals) {
+			break
+		}
+		key, _ := keyvals[i].(string)
+		if sensitiveKey(key) {
+			out[i+1] = redacted
+			continue
+		}
+		out[i+1] = l.redactValue(keyvals[i+1])
+	}
+	return out
+}
+
+func (l *redactingLogger) redactValue(value interface{}) interface{} {
+	var text string
+	switch v := value.(type) {
+	case string:
+		text = v
+	case error:
+		text = v.Error()
+	case fmt.Stringer:
+		text = v.String()
+	default:
+		return value
+	}
+	if cleaned := l.redact(text); cleaned != text {
+		return cleaned
+	}
+	return value
+}
+
+func (l *redactingLogger) redact(text string) string {
+	for _, secret := range l.secrets {
+		text = strings.ReplaceAll(text, secret, redacted)
+	}
+	return text
+}
+
+// sensitiveKey reports whether a log key names a credential, such as apiKey or auth_token
+func sensitiveKey(key string) bool {
+	key = strings.ToLower(strings.NewReplacer("_", "", "-", "").Replace(key))
+	for _, name := range []string{"apikey", "authtoken", "token", "password", "secret"} {
+		if strings.HasSuffix(key, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,38 @@
+//go:build go1.21
+// +build go1.21
+
+package elarian
+
+import (
+	"context"
+	"log/slog"
+)
+
+// slogLogger adapts a *slog.Logger to Logger
+type slogLogger struct {
+	logger *slog.Logger
+}
+
+// NewSlogLogger returns a Logger that writes to logger, if logger is nil slog's default logger is used
+func NewSlogLogger(logger *slog.Logger) Logger {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &slogLogger{logger: logger}
+}
+
+func (l *slogLogger) Debug(msg string, keyvals ...interface{}) {
+	l.logger.Log(context.Background(), slog.LevelDebug, msg, keyvals...)
+}
+
+func (l *slogLogger) Info(msg string, keyvals ...interface{}) {
+	l.logger.Log(context.Background(), slog.LevelInfo, msg, keyvals...)
+}
+
+func (l *slogLogger) Warn(msg string, keyvals ...interface{}) {
+	l.logger.Log(context.Background(), slog.LevelWarn, msg, keyvals...)
+}
+
+func (l *slogLogger) Error(msg string, keyvals ...interface{}) {
+	l.logger.Log(context.Background(), slog.LevelError, msg, keyvals...)
+}
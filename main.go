@@ -3,27 +3,42 @@ package elarian
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
-	"log"
-	"reflect"
+	"net"
+	"net/http"
+	"strconv"
 	"time"
 
 	hera "github.com/elarianltd/go-sdk/com_elarian_hera_proto"
 	"github.com/rsocket/rsocket-go"
+	"github.com/rsocket/rsocket-go/core/transport"
 	"github.com/rsocket/rsocket-go/payload"
+	"github.com/rsocket/rsocket-go/rx"
 	"github.com/rsocket/rsocket-go/rx/mono"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/wrapperspb"
 )
 
 type (
+	// Transport defines the rsocket transport used to connect to elarian
+	Transport int32
+
 	service struct {
-		host                         string
-		port                         int
-		errorChannel                 chan<- error
-		replyChannel                 <-chan *hera.ServerToAppNotificationReply
-		notificationChannel          chan<- *hera.ServerToAppNotification
-		simulatorNotificationChannel chan<- *hera.ServerToSimulatorNotification
+		host         string
+		port         int
+		logger       Logger
+		metrics      MetricsCollector
+		tracer       trace.Tracer
+		propagator   propagation.TextMapPropagator
+		errorChannel chan<- error
+		pool         *notificationPool
+		journal      *journal
+		recorder     *recorder
+		gate         *notificationGate
 	}
 
 	// Options Elarain initialization options
@@ -35,18 +50,190 @@ type (
 		IsSimulator        bool   `json:"isSimulator,omitempty"`
 		AllowNotifications bool   `json:"allowNotifications,omitempty"`
 		Log                bool   `json:"log,omitempty"`
+
+		// Logger receives the sdk's logs, when it is nil and Log is set logs at the info level and above are written to the standard logger.
+		// The APIKey and AuthToken are redacted from every log
+		Logger Logger `json:"-"`
+
+		// Metrics, when set, receives the count and latency of commands, notifications, handlers, reply timeouts and reconnects. See NewPrometheusMetrics
+		Metrics MetricsCollector `json:"-"`
+
+		// TracerProvider creates the spans commands are sent and notifications are handled in, the global provider is used when it is nil
+		TracerProvider trace.TracerProvider `json:"-"`
+
+		// Propagator, when set, propagates the trace context of commands and notification replies to elarian in the rsocket metadata
+		// and continues the trace context elarian sends with notifications. It should only be set when elarian is known to support it
+		Propagator propagation.TextMapPropagator `json:"-"`
+
+		// RetryPolicy, when set, sends commands that fail with transient errors again. Commands are sent once when it is nil
+		RetryPolicy *RetryPolicy `json:"retryPolicy,omitempty"`
+
+		// StatusErrors makes commands that elarian replies to with a failed status, such as a message without consent or a payment with insufficient funds,
+		// return a *CommandError instead of their reply. Interceptors still see the reply
+		StatusErrors bool `json:"statusErrors,omitempty"`
+
+		// CommandInterceptors are added with UseCommandInterceptors when the service is created
+		CommandInterceptors []CommandInterceptor `json:"-"`
+
+		// Workers is the number of notifications that are handled concurrently, it defaults to 1.
+		// Notifications about the same customer are always handled one at a time in the order they were received.
+		Workers int `json:"workers,omitempty"`
+
+		// QueueSize is the number of notifications each worker holds before Backpressure applies, it defaults to 64
+		QueueSize    int                `json:"queueSize,omitempty"`
+		Backpressure BackpressurePolicy `json:"backpressure,omitempty"`
+
+		// ReplyTimeout is how long handlers have to reply to a notification before DefaultReply is sent back to elarian, it defaults to 15 seconds.
+		// ReplyTimeouts overrides it for specific notifications.
+		ReplyTimeout  time.Duration                  `json:"replyTimeout,omitempty"`
+		ReplyTimeouts map[Notification]time.Duration `json:"replyTimeouts,omitempty"`
+
+		// DefaultReply builds the reply sent when a notification times out, an empty reply is sent when it is nil
+		DefaultReply DefaultReplyFunc `json:"-"`
+
+		// OnReplyTimeout is called every time a notification times out
+		OnReplyTimeout ReplyTimeoutHandler `json:"-"`
+
+		// DedupStore, when set, makes sure a notification elarian delivers more than once is only handled once.
+		// Notifications are identified by their transaction ID, message ID or reminder work ID and statuses, duplicates get an empty reply.
+		// Notifications whose handlers fail, or return without replying before the reply timeout, are removed from the store so that they are handled
+		// when elarian delivers them again. Handlers that reply after the timeout keep their notification in the store,
+		// set JournalPath as well for notifications that were being handled when the process stopped to be replayed.
+		DedupStore DedupStore `json:"-"`
+
+		// JournalPath, when set, is the file every notification is logged to before it is handled.
+		// Notifications whose handlers had not replied, or were still running after the default reply was sent, when the service stopped are replayed
+		// to the handlers the next time it starts.
+		JournalPath string `json:"journalPath,omitempty"`
+
+		// RecordPath, when set, is the file every notification frame received from elarian is recorded to in RecordFormat, which defaults to JSON.
+		// Recordings can be read with ReadRecording and fed back to handlers offline with a Replayer.
+		RecordPath   string       `json:"recordPath,omitempty"`
+		RecordFormat RecordFormat `json:"recordFormat,omitempty"`
+
+		// CustomerLookup decides how the customer number is filled in for notifications that do not carry one, it defaults to CustomerLookupCached.
+		// Customer numbers are cached for CustomerCacheTTL, which defaults to 10 minutes.
+		CustomerLookup   CustomerLookup `json:"customerLookup,omitempty"`
+		CustomerCacheTTL time.Duration  `json:"customerCacheTtl,omitempty"`
 	}
 
-	// ConnectionOptions RSocket connection options
+	// ConnectionOptions RSocket connection options.
+	// Host and Port default to the elarian production endpoint and the connection is secured with tls unless Insecure is set.
 	ConnectionOptions struct {
 		LifeTime   time.Duration `json:"lifeTime,omitempty"`
 		Keepalive  time.Duration `json:"keepAlive,omitempty"`
 		MissedAcks int           `json:"missedAcks,omitempty"`
-		Resumable  bool          `json:"resumable,omitempty"`
+
+		// Resumable resumes the rsocket session on a new transport when the transport is lost instead of setting up a new one.
+		// The reconnect options apply to the attempts to resume it, which rsocket makes at most once a second.
+		Resumable bool `json:"resumable,omitempty"`
+
+		Host      string    `json:"host,omitempty"`
+		Port      int       `json:"port,omitempty"`
+		Transport Transport `json:"transport,omitempty"`
+
+		// WebSocketPath is appended to the websocket url when the websocket transport is used
+		WebSocketPath string `json:"webSocketPath,omitempty"`
+
+		// RootCAs is the certificate pool used to verify the server. The host's root pool is used when it is nil
+		RootCAs *x509.CertPool `json:"-"`
+
+		// Certificates are presented to the server when it requests client authentication
+		Certificates []tls.Certificate `json:"-"`
+
+		// Insecure disables tls and connects over plaintext. It should only be used against local or test servers
+		Insecure bool `json:"insecure,omitempty"`
+
+		// ReconnectBackoff is the delay before the first reconnection attempt after the connection is lost.
+		// It doubles with every failed attempt up to MaxReconnectBackoff and half of it is randomized.
+		ReconnectBackoff    time.Duration `json:"reconnectBackoff,omitempty"`
+		MaxReconnectBackoff time.Duration `json:"maxReconnectBackoff,omitempty"`
+
+		// MaxReconnectAttempts limits the reconnection attempts after the connection is lost, zero means no limit
+		MaxReconnectAttempts int `json:"maxReconnectAttempts,omitempty"`
+
+		// DisableReconnect closes the service instead of reconnecting when the connection is lost
+		DisableReconnect bool `json:"disableReconnect,omitempty"`
 	}
 )
 
-func (s *service) connect(options *Options, connectionOptions *ConnectionOptions) (rsocket.Client, error) {
+// Transport constants. The websocket transport honours the HTTP_PROXY and HTTPS_PROXY environment variables.
+const (
+	TransportTCP Transport = iota
+	TransportWebSocket
+)
+
+const (
+	defaultHost string = "tcp.elarian.dev"
+	defaultPort int    = 8082
+)
+
+// withConnectionDefaults returns a copy of the provided connection options with unset values replaced by their defaults
+func withConnectionDefaults(connectionOptions *ConnectionOptions) *ConnectionOptions {
+	opts := &ConnectionOptions{}
+	if connectionOptions != nil {
+		*opts = *connectionOptions
+	}
+	if opts.Host == "" {
+		opts.Host = defaultHost
+	}
+	if opts.Port == 0 {
+		opts.Port = defaultPort
+	}
+	if opts.Keepalive == 0 {
+		opts.Keepalive = time.Duration(time.Second * 2)
+	}
+	if opts.LifeTime == 0 {
+		opts.LifeTime = time.Duration(time.Second * 1)
+	}
+	if opts.MissedAcks == 0 {
+		opts.MissedAcks = 6
+	}
+	if opts.ReconnectBackoff == 0 {
+		opts.ReconnectBackoff = time.Duration(time.Millisecond * 500)
+	}
+	if opts.MaxReconnectBackoff == 0 {
+		opts.MaxReconnectBackoff = time.Duration(time.Second * 30)
+	}
+	return opts
+}
+
+func (s *service) tlsConfig(connectionOptions *ConnectionOptions) *tls.Config {
+	if connectionOptions.Insecure {
+		return nil
+	}
+	return &tls.Config{
+		ServerName:   s.host,
+		RootCAs:      connectionOptions.RootCAs,
+		Certificates: connectionOptions.Certificates,
+	}
+}
+
+func (s *service) transport(connectionOptions *ConnectionOptions) (transport.ClientTransporter, error) {
+	switch connectionOptions.Transport {
+	case TransportTCP:
+		builder := rsocket.TCPClient().SetHostAndPort(s.host, s.port)
+		if tlsConfig := s.tlsConfig(connectionOptions); tlsConfig != nil {
+			builder.SetTLSConfig(tlsConfig)
+		}
+		return builder.Build(), nil
+	case TransportWebSocket:
+		scheme := "wss"
+		if connectionOptions.Insecure {
+			scheme = "ws"
+		}
+		url := fmt.Sprintf("%s://%s%s", scheme, net.JoinHostPort(s.host, strconv.Itoa(s.port)), connectionOptions.WebSocketPath)
+		builder := rsocket.WebsocketClient().SetURL(url).SetProxy(http.ProxyFromEnvironment)
+		if tlsConfig := s.tlsConfig(connectionOptions); tlsConfig != nil {
+			builder.SetTLSConfig(tlsConfig)
+		}
+		return builder.Build(), nil
+	default:
+		return nil, fmt.Errorf("unsupported transport %d", connectionOptions.Transport)
+	}
+}
+
+func (s *service) connect(options *Options, connectionOptions *ConnectionOptions) (*connection, error) {
 	metadata := &hera.AppConnectionMetadata{
 		OrgId:         options.OrgID,
 		AppId:         options.AppID,
@@ -58,53 +245,55 @@ func (s *service) connect(options *Options, connectionOptions *ConnectionOptions
 
 	data, err := proto.Marshal(metadata)
 	if err != nil {
-		log.Println("Error marshaling metadata", err)
+		return nil, fmt.Errorf("marshaling connection metadata: %w", err)
 	}
 
-	onConnect := func(c rsocket.Client, err error) {
-		if err != nil {
-			log.Fatalf("Error on connection: %v \n", err)
-			return
+	notificationHandler := func(notification *hera.ServerToAppNotification, metadata []byte) mono.Mono {
+		// the request is done once its reply has been handed to rsocket and the dispatcher has finished handling it
+		if !s.gate.accept() {
+			return mono.Error(errShuttingDown)
 		}
-		if options.Log {
-			log.Println("Connected to elarian successfully")
+		req := newNotificationRequest(notification)
+		req.parent, req.propagator = extractMetadata(metadata, s.propagator), s.propagator
+		s.journaled(req)
+		if err := s.pool.submit(s.gate.ctx, &notificationJob{key: notificationKey(notification), request: req}); err != nil {
+			// elarian delivers rejected notifications again, they are not replayed from the journal
+			req.complete()
+			s.gate.replied()
+			s.gate.leave()
+			return mono.Error(rejectedNotificationError(err))
 		}
+		// the reply is waited for off the connection's goroutine so that other notifications keep arriving meanwhile
+		return mono.Create(func(ctx context.Context, sink mono.Sink) {
+			go func() {
+				select {
+				case reply := <-req.reply:
+					data, err := proto.Marshal(reply)
+					if err != nil {
+						s.reportError(fmt.Errorf("Marshling error: %w ", err))
+					}
+					sink.Success(payload.New(data, req.metadata))
+				case <-ctx.Done():
+					sink.Error(ctx.Err())
+				case <-s.gate.ctx.Done():
+					sink.Error(errShuttingDown)
+				}
+			}()
+		}).DoFinally(s.leaveGate)
 	}
 
-	onClose := func(err error) {
-		if err != nil {
-			log.Printf("Error closing connection: %v \n", err)
-			return
+	simulatorNotificationHandler := func(req *hera.ServerToSimulatorNotification) mono.Mono {
+		if !s.gate.accept() {
+			return mono.Error(errShuttingDown)
 		}
-		close(s.errorChannel)
-		close(s.notificationChannel)
-		close(s.simulatorNotificationChannel)
-		if options.Log {
-			log.Println("Elarian connection closed successfully")
+		if err := s.pool.submit(s.gate.ctx, &notificationJob{simulator: req}); err != nil {
+			s.gate.replied()
+			s.gate.leave()
+			return mono.Error(rejectedNotificationError(err))
 		}
-	}
-
-	notificationHandler := func(req *hera.ServerToAppNotification) mono.Mono {
-		s.notificationChannel <- req
-		select {
-		case <-time.After(time.Second * 15):
-			reply := new(hera.ServerToAppNotificationReply)
-			data, _ := proto.Marshal(reply)
-			return mono.Just(payload.New(data, []byte{}))
-		case reply := <-s.replyChannel:
-			data, err := proto.Marshal(reply)
-			if err != nil {
-				s.errorChannel <- fmt.Errorf("Marshling error: %w ", err)
-			}
-			return mono.Just(payload.New(data, []byte{}))
-		}
-	}
-
-	simulatorNotificationHandler := func(req *hera.ServerToSimulatorNotification) mono.Mono {
-		s.simulatorNotificationChannel <- req
 		reply := new(hera.ServerToSimulatorNotificationReply)
 		data, _ := proto.Marshal(reply)
-		return mono.Just(payload.New(data, []byte{}))
+		return mono.Just(payload.New(data, []byte{})).DoFinally(s.leaveGate)
 	}
 
 	acceptor := func(ctx context.Context, socket rsocket.RSocket) rsocket.RSocket {
@@ -112,10 +301,13 @@ func (s *service) connect(options *Options, connectionOptions *ConnectionOptions
 			rsocket.RequestResponse(func(msg payload.Payload) (response mono.Mono) {
 				req := new(hera.ServerToAppNotification)
 				if err := proto.Unmarshal(msg.Data(), req); err == nil {
-					return notificationHandler(req)
+					s.recorded(false, req, req.GetCustomer().GetCustomerId())
+					metadata, _ := msg.Metadata()
+					return notificationHandler(req, metadata)
 				}
 				simReq := new(hera.ServerToSimulatorNotification)
 				if err := proto.Unmarshal(msg.Data(), simReq); err == nil {
+					s.recorded(true, simReq, "")
 					return simulatorNotificationHandler(simReq)
 				}
 				reply := new(hera.ServerToSimulatorNotificationReply)
@@ -124,42 +316,72 @@ func (s *service) connect(options *Options, connectionOptions *ConnectionOptions
 			}))
 	}
 
-	tp := rsocket.TCPClient().
-		SetHostAndPort(s.host, s.port).
-		SetTLSConfig(&tls.Config{ServerName: s.host}).
-		Build()
+	tp, err := s.transport(connectionOptions)
+	if err != nil {
+		return nil, err
+	}
 
-	connectionOpts := &ConnectionOptions{
-		MissedAcks: 6,
-		Resumable:  true,
+	conn := newConnection(connectionOptions)
+	conn.reportError = s.reportError
+	conn.onClosed = s.gate.finish
+	conn.metrics = s.metrics
+	conn.OnStateChange(func(state ConnectionState) {
+		switch state {
+		case ConnectionStateReady:
+			s.logger.Info("connected to elarian", "host", s.host, "port", s.port)
+		case ConnectionStateReconnecting:
+			s.logger.Warn("lost connection to elarian, reconnecting", "host", s.host, "port", s.port)
+		case ConnectionStateClosed:
+			s.logger.Info("elarian connection closed", "host", s.host, "port", s.port)
+		}
+	})
+	conn.dial = func(ctx context.Context, onClose func(error)) (rsocket.Client, error) {
+		builder, transporter := rsocket.Connect(), tp
+		if connectionOptions.Resumable {
+			builder, transporter = builder.Resume(), conn.resumable(tp)
+		}
+		return builder.
+			KeepAlive(connectionOptions.Keepalive, connectionOptions.LifeTime, connectionOptions.MissedAcks).
+			MetadataMimeType("application/octet-stream").
+			DataMimeType("application/octet-stream").
+			OnClose(onClose).
+			SetupPayload(payload.New(data, nil)).
+			Acceptor(acceptor).
+			Transport(transporter).
+			Start(ctx)
+	}
+
+	if err := conn.start(); err != nil {
+		return nil, err
 	}
+	return conn, nil
+}
 
-	if reflect.ValueOf(connectionOptions).IsZero() {
-		connectionOpts.Keepalive = time.Duration(time.Second * 2)
-		connectionOpts.LifeTime = time.Duration(time.Second * 1)
-	} else {
-		connectionOpts.Keepalive = connectionOptions.Keepalive
-		connectionOpts.LifeTime = connectionOptions.LifeTime
+// rejectedNotificationError returns the error elarian is sent for a notification that could not be queued
+func rejectedNotificationError(err error) error {
+	if errors.Is(err, errQueueFull) {
+		return err
 	}
+	return errShuttingDown
+}
 
-	client, err := rsocket.Connect().
-		KeepAlive(connectionOpts.Keepalive, connectionOpts.LifeTime, connectionOpts.MissedAcks).
-		MetadataMimeType("application/octet-stream").
-		DataMimeType("application/octet-stream").
-		OnClose(onClose).
-		OnConnect(onConnect).
-		SetupPayload(payload.New(data, nil)).
-		Acceptor(acceptor).
-		Transport(tp).
-		Start(context.Background())
+// leaveGate marks a notification reply as sent once rsocket is done with it
+func (s *service) leaveGate(rx.SignalType) {
+	s.gate.replied()
+}
 
-	if err != nil {
-		log.Fatalf("Error on connection: %v \n", err)
+// reportError surfaces asynchronous connection errors on the error channel returned by InitializeNotificationStream.
+// Errors are dropped when the channel is full so that the rsocket goroutines are never blocked.
+func (s *service) reportError(err error) {
+	select {
+	case s.errorChannel <- err:
+	default:
+		s.logger.Warn("dropped elarian error", "error", err)
 	}
-	return client, err
 }
 
-// Connect establishes a connection to elarian
+// Connect establishes a connection to elarian.
+// Connection failures are returned as a *ConnectionError, use errors.Is with ErrAuthFailed, ErrUnreachable or ErrSetupRejected to check their kind.
 func Connect(options *Options, connectionOptions *ConnectionOptions) (Elarian, error) {
 	return NewService(options, connectionOptions)
 }
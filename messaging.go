@@ -7,8 +7,6 @@ import (
 	"time"
 
 	hera "github.com/elarianltd/go-sdk/com_elarian_hera_proto"
-	"github.com/golang/protobuf/proto"
-	"github.com/rsocket/rsocket-go/payload"
 	"google.golang.org/protobuf/types/known/durationpb"
 	"google.golang.org/protobuf/types/known/timestamppb"
 	"google.golang.org/protobuf/types/known/wrapperspb"
@@ -289,20 +287,12 @@ func (s *elarian) SendMessage(ctx context.Context, number *CustomerNumber, chann
 	req := &hera.AppToServerCommand{
 		Entry: &hera.AppToServerCommand_SendMessage{SendMessage: command},
 	}
-	data, err := proto.Marshal(req)
-	if err != nil {
-		return nil, err
-	}
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
 	defer cancel()
-	res, err := s.client.RequestResponse(payload.New(data, []byte{})).Block(ctx)
+	reply, err := s.sendCommand(ctx, req)
 	if err != nil {
 		return nil, err
 	}
-	reply := new(hera.AppToServerCommandReply)
-	if err = proto.Unmarshal(res.Data(), reply); err != nil {
-		return nil, err
-	}
 	return &SendMessageReply{
 		CustomerID:  reply.GetSendMessage().CustomerId.Value,
 		Description: reply.GetSendMessage().Description,
@@ -357,20 +347,12 @@ func (s *elarian) SendMessageByTag(ctx context.Context, tag *Tag, channelNumber
 	req := &hera.AppToServerCommand{
 		Entry: &hera.AppToServerCommand_SendMessageTag{SendMessageTag: command},
 	}
-	data, err := proto.Marshal(req)
-	if err != nil {
-		return nil, err
-	}
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
 	defer cancel()
-	res, err := s.client.RequestResponse(payload.New(data, []byte{})).Block(ctx)
+	reply, err := s.sendCommand(ctx, req)
 	if err != nil {
 		return nil, err
 	}
-	reply := new(hera.AppToServerCommandReply)
-	if err = proto.Unmarshal(res.Data(), reply); err != nil {
-		return nil, err
-	}
 	return &TagCommandReply{
 		Status:      reply.GetTagCommand().Status,
 		Description: reply.GetTagCommand().Description,
@@ -410,20 +392,12 @@ func (s *elarian) ReplyToMessage(ctx context.Context, customerID, messageID stri
 	req := &hera.AppToServerCommand{
 		Entry: &hera.AppToServerCommand_ReplyToMessage{ReplyToMessage: command},
 	}
-	data, err := proto.Marshal(req)
-	if err != nil {
-		return nil, err
-	}
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
 	defer cancel()
-	res, err := s.client.RequestResponse(payload.New(data, []byte{})).Block(ctx)
+	reply, err := s.sendCommand(ctx, req)
 	if err != nil {
 		return nil, err
 	}
-	reply := &hera.AppToServerCommandReply{}
-	if err = proto.Unmarshal(res.Data(), reply); err != nil {
-		return nil, err
-	}
 	return &SendMessageReply{
 		CustomerID:  reply.GetSendMessage().CustomerId.Value,
 		Description: reply.GetSendMessage().Description,
@@ -528,20 +502,12 @@ func (s *elarian) ReceiveMessage(ctx context.Context, customerNumber string, cha
 	req := &hera.SimulatorToServerCommand{
 		Entry: &hera.SimulatorToServerCommand_ReceiveMessage{ReceiveMessage: command},
 	}
-	data, err := proto.Marshal(req)
-	if err != nil {
-		return nil, err
-	}
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
 	defer cancel()
-	payload, err := s.client.RequestResponse(payload.New(data, []byte{})).Block(ctx)
+	reply, err := s.sendSimulatorCommand(ctx, req)
 	if err != nil {
 		return nil, err
 	}
-	reply := new(hera.SimulatorToServerCommandReply)
-	if err = proto.Unmarshal(payload.Data(), reply); err != nil {
-		return nil, err
-	}
 	return &SimulatorToServerCommandReply{
 		Status:      reply.Status,
 		Message:     s.OutboundMessage(reply.Message),
@@ -0,0 +1,274 @@
+package elarian
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// MetricsCollector receives measurements of the commands the sdk sends and the notifications it handles.
+	// Implementations must be safe to call from multiple goroutines and should not block.
+	MetricsCollector interface {
+		// CommandSent is called once the reply to a command is received or the command fails, command is the name of the command such as get_customer_state
+		CommandSent(command string, duration time.Duration, err error)
+
+		// NotificationReceived is called for every notification received from elarian before it is dispatched
+		NotificationReceived(notification Notification)
+
+		// HandlerFinished is called every time a handler returns, err is the error it returned or the panic it recovered from
+		HandlerFinished(notification Notification, duration time.Duration, err error)
+
+		// ReplyTimedOut is called every time the default reply is sent for a notification that was not replied to in time
+		ReplyTimedOut(notification Notification)
+
+		// Reconnected is called every time the connection to elarian is re-established after it was lost
+		Reconnected()
+	}
+
+	// PrometheusMetrics is a MetricsCollector that serves its metrics in the prometheus text exposition format
+	PrometheusMetrics struct {
+		mu               sync.Mutex
+		namespace        string
+		commands         map[[2]string]uint64
+		commandDurations map[string]*histogram
+		notifications    map[string]uint64
+		handlerDurations map[[2]string]*histogram
+		replyTimeouts    map[string]uint64
+		reconnects       uint64
+	}
+
+	// histogram counts durations in buckets by their upper bound in seconds
+	histogram struct {
+		bounds  []float64
+		buckets []uint64
+		count   uint64
+		sum     float64
+	}
+
+	// countingWriter keeps the number of bytes written and the first error
+	countingWriter struct {
+		w   *bufio.Writer
+		n   int64
+		err error
+	}
+
+	nopMetrics struct{}
+)
+
+// NewPrometheusMetrics returns an empty PrometheusMetrics, metric names are prefixed with namespace which defaults to elarian
+func NewPrometheusMetrics(namespace string) *PrometheusMetrics {
+	if namespace == "" {
+		namespace = "elarian"
+	}
+	return &PrometheusMetrics{
+		namespace:        namespace,
+		commands:         make(map[[2]string]uint64),
+		commandDurations: make(map[string]*histogram),
+		notifications:    make(map[string]uint64),
+		handlerDurations: make(map[[2]string]*histogram),
+		replyTimeouts:    make(map[string]uint64),
+	}
+}
+
+// CommandSent counts the command by its name and result and observes its latency
+func (m *PrometheusMetrics) CommandSent(command string, duration time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.commands[[2]string{command, result(err)}]++
+	observe(m.commandDurations, command, duration)
+}
+
+// NotificationReceived counts the notification by its kind
+func (m *PrometheusMetrics) NotificationReceived(notification Notification) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.notifications[notification.String()]++
+}
+
+// HandlerFinished observes how long the handler took by the kind of notification it handled and its result
+func (m *PrometheusMetrics) HandlerFinished(notification Notification, duration time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := [2]string{notification.String(), result(err)}
+	if m.handlerDurations[key] == nil {
+		m.handlerDurations[key] = newHistogram()
+	}
+	m.handlerDurations[key].observe(duration)
+}
+
+// ReplyTimedOut counts the timeout by the kind of notification
+func (m *PrometheusMetrics) ReplyTimedOut(notification Notification) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.replyTimeouts[notification.String()]++
+}
+
+// Reconnected counts the reconnection
+func (m *PrometheusMetrics) Reconnected() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.reconnects++
+}
+
+// ServeHTTP writes the metrics so that PrometheusMetrics can be registered as the handler prometheus scrapes
+func (m *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
+
+// WriteTo writes the metrics in the prometheus text exposition format
+func (m *PrometheusMetrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := &countingWriter{w: bufio.NewWriter(w)}
+
+	name := m.namespace + "_commands_total"
+	out.printf("# HELP %s Commands sent to elarian by command and result.\n# TYPE %s counter\n", name, name)
+	for _, key := range sortedPairs(m.commands) {
+		out.printf("%s{command=%s,result=%s} %d\n", name, quote(key[0]), quote(key[1]), m.commands[key])
+	}
+
+	name = m.namespace + "_command_duration_seconds"
+	out.printf("# HELP %s Time taken for elarian to reply to commands.\n# TYPE %s histogram\n", name, name)
+	commands := make([]string, 0, len(m.commandDurations))
+	for command := range m.commandDurations {
+		commands = append(commands, command)
+	}
+	sort.Strings(commands)
+	for _, command := range commands {
+		m.commandDurations[command].write(out, name, "command="+quote(command))
+	}
+
+	name = m.namespace + "_notifications_total"
+	out.printf("# HELP %s Notifications received from elarian by notification.\n# TYPE %s counter\n", name, name)
+	for _, notification := range sortedKeys(m.notifications) {
+		out.printf("%s{notification=%s} %d\n", name, quote(notification), m.notifications[notification])
+	}
+
+	name = m.namespace + "_handler_duration_seconds"
+	out.printf("# HELP %s Time taken by notification handlers by notification and result.\n# TYPE %s histogram\n", name, name)
+	handlers := make([][2]string, 0, len(m.handlerDurations))
+	for key := range m.handlerDurations {
+		handlers = append(handlers, key)
+	}
+	sortPairs(handlers)
+	for _, key := range handlers {
+		m.handlerDurations[key].write(out, name, "notification="+quote(key[0])+",result="+quote(key[1]))
+	}
+
+	name = m.namespace + "_reply_timeouts_total"
+	out.printf("# HELP %s Notifications that were sent the default reply after their reply timeout.\n# TYPE %s counter\n", name, name)
+	for _, notification := range sortedKeys(m.replyTimeouts) {
+		out.printf("%s{notification=%s} %d\n", name, quote(notification), m.replyTimeouts[notification])
+	}
+
+	name = m.namespace + "_reconnects_total"
+	out.printf("# HELP %s Times the connection to elarian was re-established after it was lost.\n# TYPE %s counter\n", name, name)
+	out.printf("%s %d\n", name, m.reconnects)
+
+	if out.err == nil {
+		out.err = out.w.Flush()
+	}
+	return out.n, out.err
+}
+
+func newHistogram() *histogram {
+	bounds := []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+	return &histogram{bounds: bounds, buckets: make([]uint64, len(bounds))}
+}
+
+func observe(histograms map[string]*histogram, key string, duration time.Duration) {
+	if histograms[key] == nil {
+		histograms[key] = newHistogram()
+	}
+	histograms[key].observe(duration)
+}
+
+func (h *histogram) observe(duration time.Duration) {
+	seconds := duration.Seconds()
+	for i, bound := range h.bounds {
+		if seconds <= bound {
+			h.buckets[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+func (h *histogram) write(out *countingWriter, name, labels string) {
+	for i, bound := range h.bounds {
+		out.printf("%s_bucket{%s,le=%s} %d\n", name, labels, quote(strconv.FormatFloat(bound, 'g', -1, 64)), h.buckets[i])
+	}
+	out.printf("%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, h.count)
+	out.printf("%s_sum{%s} %s\n", name, labels, strconv.FormatFloat(h.sum, 'g', -1, 64))
+	out.printf("%s_count{%s} %d\n", name, labels, h.count)
+}
+
+func (c *countingWriter) printf(format string, args ...interface{}) {
+	if c.err != nil {
+		return
+	}
+	n, err := fmt.Fprintf(c.w, format, args...)
+	c.n += int64(n)
+	c.err = err
+}
+
+// quote returns a label value escaped for the exposition format
+func quote(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value) + `"`
+}
+
+func result(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "success"
+}
+
+func sortedKeys(counts map[string]uint64) []string {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedPairs(counts map[[2]string]uint64) [][2]string {
+	keys := make([][2]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sortPairs(keys)
+	return keys
+}
+
+func sortPairs(keys [][2]string) {
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+}
+
+func (nopMetrics) CommandSent(command string, duration time.Duration, err error)                {}
+func (nopMetrics) NotificationReceived(notification Notification)                               {}
+func (nopMetrics) HandlerFinished(notification Notification, duration time.Duration, err error) {}
+func (nopMetrics) ReplyTimedOut(notification Notification)                                      {}
+func (nopMetrics) Reconnected()                                                                 {}
+
+// newMetrics returns Options.Metrics, or a collector that discards every measurement when it is nil
+func newMetrics(options *Options) MetricsCollector {
+	if options.Metrics == nil {
+		return nopMetrics{}
+	}
+	return options.Metrics
+}
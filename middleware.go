@@ -0,0 +1,162 @@
+package elarian
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+type (
+	// Middleware wraps a notification handler, it can run code before and after next and decide whether next is called at all
+	Middleware func(next NotificationHandler) NotificationHandler
+
+	// TimingObserver receives how long a handler took to handle a notification and the error it returned
+	TimingObserver func(ctx context.Context, notification Notification, duration time.Duration, err error)
+
+	// DedupKeyFunc returns the key notifications are deduplicated on, notifications with an empty key are never treated as duplicates
+	DedupKeyFunc func(ctx context.Context, notification IsNotification) string
+
+	// dedupScope identifies a DedupMiddleware in the decisions made for a notification
+	dedupScope struct {
+		store DedupStore
+	}
+
+	// dedupDecisions remembers what every DedupMiddleware decided for a notification, so that each handler it is published to gets the same decision
+	dedupDecisions struct {
+		mu        sync.Mutex
+		decisions map[dedupDecisionKey]dedupDecision
+	}
+
+	dedupDecisionKey struct {
+		scope *dedupScope
+		key   string
+	}
+
+	// dedupDecision records whether a notification was a duplicate and whether its key was added to the store, it is neither when the store failed
+	dedupDecision struct {
+		duplicate bool
+		added     bool
+	}
+)
+
+// LoggingMiddleware logs every notification that is handled along with how long it took and the error returned.
+// The service's credentials are redacted from the logs, if logger is nil the logs go to the service's logger.
+func LoggingMiddleware(logger Logger) Middleware {
+	return func(next NotificationHandler) NotificationHandler {
+		return func(ctx context.Context, service Elarian, notification IsNotification, appData *Appdata, customer *Customer, cb NotificationCallBack) error {
+			out := logger
+			if s, ok := service.(*elarian); ok {
+				out = s.redacting(logger)
+			}
+			if out == nil {
+				out = nopLogger{}
+			}
+			logged := TimingMiddleware(func(ctx context.Context, notification Notification, duration time.Duration, err error) {
+				keyvals := []interface{}{
+					"notification", notification.String(),
+					"orgId", OrgIDFromContext(ctx),
+					"appId", AppIDFromContext(ctx),
+					"customerId", CustomerIDFromContext(ctx),
+					"duration", duration,
+				}
+				if err != nil {
+					out.Error("handled notification", append(keyvals, "error", err)...)
+					return
+				}
+				out.Info("handled notification", keyvals...)
+			})
+			return logged(next)(ctx, service, notification, appData, customer, cb)
+		}
+	}
+}
+
+// TimingMiddleware calls observe with the time each handler took, handlers that panic are observed with the panic as their error
+func TimingMiddleware(observe TimingObserver) Middleware {
+	return func(next NotificationHandler) NotificationHandler {
+		return func(ctx context.Context, service Elarian, notification IsNotification, appData *Appdata, customer *Customer, cb NotificationCallBack) (err error) {
+			event, _ := NotificationFromContext(ctx)
+			started := time.Now()
+			defer func() {
+				if value := recover(); value != nil {
+					err = handlerPanic(ctx, event, value)
+				}
+				observe(ctx, event, time.Since(started), err)
+			}()
+			return next(ctx, service, notification, appData, customer, cb)
+		}
+	}
+}
+
+// RecoveryMiddleware turns a panic in the handlers it wraps into a returned *HandlerError.
+// Panics are always recovered once they reach the service, this middleware lets the middlewares added before it see them as errors.
+func RecoveryMiddleware() Middleware {
+	return func(next NotificationHandler) NotificationHandler {
+		return func(ctx context.Context, service Elarian, notification IsNotification, appData *Appdata, customer *Customer, cb NotificationCallBack) (err error) {
+			defer func() {
+				if value := recover(); value != nil {
+					event, _ := NotificationFromContext(ctx)
+					err = handlerPanic(ctx, event, value)
+				}
+			}()
+			return next(ctx, service, notification, appData, customer, cb)
+		}
+	}
+}
+
+// DedupMiddleware skips notifications whose key is already in store and replies to them with an empty reply, store defaults to an in memory LRUDedupStore.
+// The key is added once per notification, every handler the notification is published to is either called or skipped.
+// It is removed again when a handler fails or panics so that the notification is handled when elarian redelivers it,
+// notifications are handled as usual when the store cannot be reached
+func DedupMiddleware(store DedupStore, key DedupKeyFunc) Middleware {
+	if store == nil {
+		store = NewLRUDedupStore(0)
+	}
+	scope := &dedupScope{store: store}
+	return func(next NotificationHandler) NotificationHandler {
+		return func(ctx context.Context, service Elarian, notification IsNotification, appData *Appdata, customer *Customer, cb NotificationCallBack) (err error) {
+			k := key(ctx, notification)
+			if k == "" {
+				return next(ctx, service, notification, appData, customer, cb)
+			}
+			decision := scope.decide(ctx, k)
+			if decision.duplicate {
+				cb(nil, nil)
+				return nil
+			}
+			if !decision.added {
+				return next(ctx, service, notification, appData, customer, cb)
+			}
+			handled := false
+			defer func() {
+				if handled {
+					return
+				}
+				if removeErr := store.Remove(ctx, k); removeErr != nil && err != nil {
+					err = fmt.Errorf("%w, removing dedup key %q: %v", err, k, removeErr)
+				}
+			}()
+			err = next(ctx, service, notification, appData, customer, cb)
+			handled = err == nil
+			return err
+		}
+	}
+}
+
+// decide adds key to the store the first time the notification in ctx is published to a handler, later handlers get the same decision
+func (s *dedupScope) decide(ctx context.Context, key string) dedupDecision {
+	decisions, ok := ctx.Value(dedupContextKey).(*dedupDecisions)
+	if ok {
+		decisions.mu.Lock()
+		defer decisions.mu.Unlock()
+		if decision, ok := decisions.decisions[dedupDecisionKey{s, key}]; ok {
+			return decision
+		}
+	}
+	added, err := s.store.Add(ctx, key)
+	decision := dedupDecision{duplicate: err == nil && !added, added: err == nil && added}
+	if ok {
+		decisions.decisions[dedupDecisionKey{s, key}] = decision
+	}
+	return decision
+}
@@ -7,7 +7,7 @@ import (
 	hera "github.com/elarianltd/go-sdk/com_elarian_hera_proto"
 )
 
-func (s *elarian) reminderNotificationHandler(notf *hera.ServerToAppCustomerNotification) {
+func (s *elarian) reminderNotificationHandler(ctx context.Context, notf *hera.ServerToAppCustomerNotification, cb NotificationCallBack) {
 	if notf == nil || reflect.ValueOf(notf).IsZero() {
 		return
 	}
@@ -23,37 +23,13 @@ func (s *elarian) reminderNotificationHandler(notf *hera.ServerToAppCustomerNoti
 				appData.BytesValue = val.BytesVal
 			}
 		}
-		// Reminder Notifications do not come with a customer Number so we fetch a customer's state and add the customer number through that
-		state, err := customer.GetState(context.Background())
-		if err != nil {
-			// if we encounter an error fetch state at this point. we publish the reminder notification as is
-			s.bus.Publish(string(ElarianReminderNotification), s, reminder, appData, customer, s.notificationCallBack)
-			return
-		}
-
-		if state.Data != nil && state.Data.ActivityState != nil {
-			customerNumbers := state.Data.ActivityState.CustomerNumbers
-			if len(customerNumbers) > 0 {
-				customer.CustomerNumber = s.customerNumber(customerNumbers[0])
-				s.bus.Publish(string(ElarianReminderNotification), s, reminder, appData, customer, s.notificationCallBack)
-				return
-			}
-		}
-		if state.Data != nil && state.Data.MessagingState != nil {
-			channels := state.Data.MessagingState.Channels
-			if len(channels) > 0 {
-				channel := channels[0]
-				heraCustomerNumber := channel.GetActive().CustomerNumber
-				customer.CustomerNumber = s.customerNumber(heraCustomerNumber)
-				s.bus.Publish(string(ElarianReminderNotification), s, reminder, appData, customer, s.notificationCallBack)
-				return
-			}
-		}
-		s.bus.Publish(string(ElarianReminderNotification), s, reminder, appData, customer, s.notificationCallBack)
+		// Reminder Notifications do not come with a customer Number, it is filled in from the customer cache according to Options.CustomerLookup
+		s.withCustomerNumber(ctx, customer, true)
+		s.bus.publish(ctx, ElarianReminderNotification, s, reminder, appData, customer, cb)
 	}
 }
 
-func (s *elarian) messageStatusNotificationHandler(notf *hera.ServerToAppCustomerNotification) {
+func (s *elarian) messageStatusNotificationHandler(ctx context.Context, notf *hera.ServerToAppCustomerNotification, cb NotificationCallBack) {
 	if notf == nil || reflect.ValueOf(notf).IsZero() {
 		return
 	}
@@ -69,11 +45,12 @@ func (s *elarian) messageStatusNotificationHandler(notf *hera.ServerToAppCustome
 				appData.BytesValue = val.BytesVal
 			}
 		}
-		s.bus.Publish(string(ElarianMessageStatusNotification), s, statusNotification, appData, customer, s.notificationCallBack)
+		s.withCustomerNumber(ctx, customer, false)
+		s.bus.publish(ctx, ElarianMessageStatusNotification, s, statusNotification, appData, customer, cb)
 	}
 }
 
-func (s *elarian) messagingSessionStartedNotificationHandler(notf *hera.ServerToAppCustomerNotification) {
+func (s *elarian) messagingSessionStartedNotificationHandler(ctx context.Context, notf *hera.ServerToAppCustomerNotification, cb NotificationCallBack) {
 	if notf == nil || reflect.ValueOf(notf).IsZero() {
 		return
 	}
@@ -89,11 +66,12 @@ func (s *elarian) messagingSessionStartedNotificationHandler(notf *hera.ServerTo
 				appData.BytesValue = val.BytesVal
 			}
 		}
-		s.bus.Publish(string(ElarianMessagingSessionStartedNotification), s, notification, appData, customer, s.notificationCallBack)
+		s.rememberCustomerNumber(customer)
+		s.bus.publish(ctx, ElarianMessagingSessionStartedNotification, s, notification, appData, customer, cb)
 	}
 }
 
-func (s *elarian) messagingSessionRenewedNotificationHandler(notf *hera.ServerToAppCustomerNotification) {
+func (s *elarian) messagingSessionRenewedNotificationHandler(ctx context.Context, notf *hera.ServerToAppCustomerNotification, cb NotificationCallBack) {
 	if notf == nil || reflect.ValueOf(notf).IsZero() {
 		return
 	}
@@ -109,11 +87,12 @@ func (s *elarian) messagingSessionRenewedNotificationHandler(notf *hera.ServerTo
 				appData.BytesValue = val.BytesVal
 			}
 		}
-		s.bus.Publish(string(ElarianMessagingSessionRenewedNotification), s, notification, appData, customer, s.notificationCallBack)
+		s.rememberCustomerNumber(customer)
+		s.bus.publish(ctx, ElarianMessagingSessionRenewedNotification, s, notification, appData, customer, cb)
 	}
 }
 
-func (s *elarian) messagingSessionEndedNotificationHandler(notf *hera.ServerToAppCustomerNotification) {
+func (s *elarian) messagingSessionEndedNotificationHandler(ctx context.Context, notf *hera.ServerToAppCustomerNotification, cb NotificationCallBack) {
 	if notf == nil || reflect.ValueOf(notf).IsZero() {
 		return
 	}
@@ -129,11 +108,12 @@ func (s *elarian) messagingSessionEndedNotificationHandler(notf *hera.ServerToAp
 				appData.BytesValue = val.BytesVal
 			}
 		}
-		s.bus.Publish(string(ElarianMessagingSessionEndedNotification), s, notification, appData, customer, s.notificationCallBack)
+		s.rememberCustomerNumber(customer)
+		s.bus.publish(ctx, ElarianMessagingSessionEndedNotification, s, notification, appData, customer, cb)
 	}
 }
 
-func (s *elarian) messagingConsentUpdateNotificationHandler(notf *hera.ServerToAppCustomerNotification) {
+func (s *elarian) messagingConsentUpdateNotificationHandler(ctx context.Context, notf *hera.ServerToAppCustomerNotification, cb NotificationCallBack) {
 	if notf == nil || reflect.ValueOf(notf).IsZero() {
 		return
 	}
@@ -149,11 +129,12 @@ func (s *elarian) messagingConsentUpdateNotificationHandler(notf *hera.ServerToA
 				appData.BytesValue = val.BytesVal
 			}
 		}
-		s.bus.Publish(string(ElarianMessagingConsentUpdateNotification), s, notification, appData, customer, s.notificationCallBack)
+		s.rememberCustomerNumber(customer)
+		s.bus.publish(ctx, ElarianMessagingConsentUpdateNotification, s, notification, appData, customer, cb)
 	}
 }
 
-func (s *elarian) recievedMessageNotificationHandler(notf *hera.ServerToAppCustomerNotification) {
+func (s *elarian) recievedMessageNotificationHandler(ctx context.Context, notf *hera.ServerToAppCustomerNotification, cb NotificationCallBack) {
 	if notf == nil || reflect.ValueOf(notf).IsZero() {
 		return
 	}
@@ -170,33 +151,28 @@ func (s *elarian) recievedMessageNotificationHandler(notf *hera.ServerToAppCusto
 			}
 		}
 
-		for _, part := range notification.Parts {
-			if notification.ChannelNumber.Channel == MessagingChannelUssd {
-				s.bus.Publish(string(ElarianReceivedUssdSessionNotification), s, part.Ussd, appData, customer, s.notificationCallBack)
-			}
-			if notification.ChannelNumber.Channel == MessagingChannelEmail {
-				s.bus.Publish(string(ElarianReceivedEmailNotification), s, part.Email, appData, customer, s.notificationCallBack)
-			}
-			if notification.ChannelNumber.Channel == MessagingChannelVoice {
-				s.bus.Publish(string(ElarianReceivedVoiceCallNotification), s, part.Voice, appData, customer, s.notificationCallBack)
-			}
-			if notification.ChannelNumber.Channel == MessagingChannelSms {
-				s.bus.Publish(string(ElarianReceivedSmsNotification), s, part, appData, customer, s.notificationCallBack)
-			}
-			if notification.ChannelNumber.Channel == MessagingChannelTelegram {
-				s.bus.Publish(string(ElarianReceivedTelegramNotification), s, part, appData, customer, s.notificationCallBack)
-			}
-			if notification.ChannelNumber.Channel == MessagingChannelWhatsapp {
-				s.bus.Publish(string(ElarianReceivedWhatsappNotification), s, part, appData, customer, s.notificationCallBack)
-			}
-			if notification.ChannelNumber.Channel == MessagingChannelFBMessanger {
-				s.bus.Publish(string(ElarianReceivedFbMessengerNotification), s, part, appData, customer, s.notificationCallBack)
+		s.rememberCustomerNumber(customer)
+		kind := ElarianReceivedMessageNotification
+		if notification.ChannelNumber != nil {
+			kind = receivedMessageKind(notification.ChannelNumber.Channel)
+		}
+		published := false
+		if kind != ElarianReceivedMessageNotification && s.bus.subscribed(kind) {
+			for _, part := range notification.Parts {
+				if notf, ok := receivedMessagePart(kind, part); ok {
+					s.bus.publish(ctx, kind, s, notf, appData, customer, cb)
+					published = true
+				}
 			}
 		}
+		// messages without a part for their channel's handler fall back to the generic handler
+		if !published {
+			s.bus.publish(ctx, ElarianReceivedMessageNotification, s, notification, appData, customer, cb)
+		}
 	}
 }
 
-func (s *elarian) sentMesssageNotificationHandler(notf *hera.ServerToAppCustomerNotification) {
+func (s *elarian) sentMesssageNotificationHandler(ctx context.Context, notf *hera.ServerToAppCustomerNotification, cb NotificationCallBack) {
 	if notf == nil || reflect.ValueOf(notf).IsZero() {
 		return
 	}
@@ -212,11 +188,12 @@ func (s *elarian) sentMesssageNotificationHandler(notf *hera.ServerToAppCustomer
 				appData.BytesValue = val.BytesVal
 			}
 		}
-		s.bus.Publish(string(ElarianSentMessageReactionNotification), s, notification, appData, customer, s.notificationCallBack)
+		s.rememberCustomerNumber(customer)
+		s.bus.publish(ctx, ElarianSentMessageReactionNotification, s, notification, appData, customer, cb)
 	}
 }
 
-func (s *elarian) receivedPaymentNotificationHandler(notf *hera.ServerToAppCustomerNotification) {
+func (s *elarian) receivedPaymentNotificationHandler(ctx context.Context, notf *hera.ServerToAppCustomerNotification, cb NotificationCallBack) {
 	if notf == nil || reflect.ValueOf(notf).IsZero() {
 		return
 	}
@@ -232,11 +209,12 @@ func (s *elarian) receivedPaymentNotificationHandler(notf *hera.ServerToAppCusto
 				appData.BytesValue = val.BytesVal
 			}
 		}
-		s.bus.Publish(string(ElarianReceivedPaymentNotification), s, notification, appData, customer, s.notificationCallBack)
+		s.rememberCustomerNumber(customer)
+		s.bus.publish(ctx, ElarianReceivedPaymentNotification, s, notification, appData, customer, cb)
 	}
 }
 
-func (s *elarian) paymentStatusNotificationHandler(notf *hera.ServerToAppCustomerNotification) {
+func (s *elarian) paymentStatusNotificationHandler(ctx context.Context, notf *hera.ServerToAppCustomerNotification, cb NotificationCallBack) {
 	if notf == nil || reflect.ValueOf(notf).IsZero() {
 		return
 	}
@@ -252,11 +230,12 @@ func (s *elarian) paymentStatusNotificationHandler(notf *hera.ServerToAppCustome
 				appData.BytesValue = val.BytesVal
 			}
 		}
-		s.bus.Publish(string(ElarianPaymentStatusNotification), s, notification, appData, customer, s.notificationCallBack)
+		s.withCustomerNumber(ctx, customer, false)
+		s.bus.publish(ctx, ElarianPaymentStatusNotification, s, notification, appData, customer, cb)
 	}
 }
 
-func (s *elarian) walletPaymentStatusNotificationHandler(notf *hera.ServerToAppCustomerNotification) {
+func (s *elarian) walletPaymentStatusNotificationHandler(ctx context.Context, notf *hera.ServerToAppCustomerNotification, cb NotificationCallBack) {
 	if notf == nil || reflect.ValueOf(notf).IsZero() {
 		return
 	}
@@ -272,11 +251,12 @@ func (s *elarian) walletPaymentStatusNotificationHandler(notf *hera.ServerToAppC
 				appData.BytesValue = val.BytesVal
 			}
 		}
-		s.bus.Publish(string(ElarianWalletPaymentStatusNotification), s, notification, appData, customer, s.notificationCallBack)
+		s.withCustomerNumber(ctx, customer, false)
+		s.bus.publish(ctx, ElarianWalletPaymentStatusNotification, s, notification, appData, customer, cb)
 	}
 }
 
-func (s *elarian) customerActivityNotificationHandler(notf *hera.ServerToAppCustomerNotification) {
+func (s *elarian) customerActivityNotificationHandler(ctx context.Context, notf *hera.ServerToAppCustomerNotification, cb NotificationCallBack) {
 	if notf == nil || reflect.ValueOf(notf).IsZero() {
 		return
 	}
@@ -292,11 +272,12 @@ func (s *elarian) customerActivityNotificationHandler(notf *hera.ServerToAppCust
 				appData.BytesValue = val.BytesVal
 			}
 		}
-		s.bus.Publish(string(ElarianCustomerActivityNotification), s, notification, appData, customer, s.notificationCallBack)
+		s.rememberCustomerNumber(customer)
+		s.bus.publish(ctx, ElarianCustomerActivityNotification, s, notification, appData, customer, cb)
 	}
 }
 
-func (s *elarian) paymentPurseStatusNotificationHandler(notf *hera.ServerToAppNotification_Purse) {
+func (s *elarian) paymentPurseStatusNotificationHandler(ctx context.Context, notf *hera.ServerToAppNotification_Purse, cb NotificationCallBack) {
 	if reflect.ValueOf(notf).IsZero() || reflect.ValueOf(notf.Purse).IsZero() {
 		return
 	}
@@ -311,11 +292,11 @@ func (s *elarian) paymentPurseStatusNotificationHandler(notf *hera.ServerToAppNo
 			TransactionID: entry.PaymentStatus.TransactionId,
 			Status:        PaymentStatus(entry.PaymentStatus.Status),
 		}
-		s.bus.Publish(string(ElarianPaymentPurseNotifiication), s, notification, nil, nil, s.notificationCallBack)
+		s.bus.publish(ctx, ElarianPaymentPurseNotifiication, s, notification, nil, nil, cb)
 	}
 }
 
-func (s *elarian) SendChannelPaymentSimulatorNotificationHandler(notf *hera.ServerToSimulatorNotification) {
+func (s *elarian) SendChannelPaymentSimulatorNotificationHandler(ctx context.Context, notf *hera.ServerToSimulatorNotification, cb NotificationCallBack) {
 	if notf == nil || reflect.ValueOf(notf).IsZero() {
 		return
 	}
@@ -346,11 +327,11 @@ func (s *elarian) SendChannelPaymentSimulatorNotificationHandler(notf *hera.Serv
 				CustomerID: wallet.Wallet.CustomerId,
 			}
 		}
-		s.bus.Publish(string(ElarianSendChannelPaymentSimulatorNotification), s, notification, nil, nil, s.notificationCallBack)
+		s.bus.publish(ctx, ElarianSendChannelPaymentSimulatorNotification, s, notification, nil, nil, cb)
 	}
 }
 
-func (s *elarian) CheckoutPaymentSimulatorNotificationHandler(notf *hera.ServerToSimulatorNotification) {
+func (s *elarian) CheckoutPaymentSimulatorNotificationHandler(ctx context.Context, notf *hera.ServerToSimulatorNotification, cb NotificationCallBack) {
 	if entry, ok := notf.Entry.(*hera.ServerToSimulatorNotification_CheckoutPayment); ok {
 		customer := &Customer{
 			ID: entry.CheckoutPayment.CustomerId,
@@ -386,10 +367,10 @@ func (s *elarian) CheckoutPaymentSimulatorNotificationHandler(notf *hera.ServerT
 				WalletID:   wallet.Wallet.WalletId,
 			}
 		}
-		s.bus.Publish(string(ElarianCheckoutPaymentSimulatorNotification), s, notification, nil, customer, s.notificationCallBack)
+		s.bus.publish(ctx, ElarianCheckoutPaymentSimulatorNotification, s, notification, nil, customer, cb)
 	}
 }
-func (s *elarian) SendCustomerPaymentSimulatorNotificationHandler(notf *hera.ServerToSimulatorNotification) {
+func (s *elarian) SendCustomerPaymentSimulatorNotificationHandler(ctx context.Context, notf *hera.ServerToSimulatorNotification, cb NotificationCallBack) {
 	if entry, ok := notf.Entry.(*hera.ServerToSimulatorNotification_SendCustomerPayment); ok {
 		customer := &Customer{
 			ID: entry.SendCustomerPayment.CustomerId,
@@ -425,10 +406,10 @@ func (s *elarian) SendCustomerPaymentSimulatorNotificationHandler(notf *hera.Ser
 				WalletID:   wallet.Wallet.WalletId,
 			}
 		}
-		s.bus.Publish(string(ElarianSendCustomerPaymentSimulatorNotification), s, notification, nil, customer, s.notificationCallBack)
+		s.bus.publish(ctx, ElarianSendCustomerPaymentSimulatorNotification, s, notification, nil, customer, cb)
 	}
 }
-func (s *elarian) MakeVoiceCallSimulatorNotificationHandler(notf *hera.ServerToSimulatorNotification) {
+func (s *elarian) MakeVoiceCallSimulatorNotificationHandler(ctx context.Context, notf *hera.ServerToSimulatorNotification, cb NotificationCallBack) {
 	if entry, ok := notf.Entry.(*hera.ServerToSimulatorNotification_MakeVoiceCall); ok {
 		customer := &Customer{
 			ID: entry.MakeVoiceCall.CustomerId,
@@ -447,11 +428,11 @@ func (s *elarian) MakeVoiceCallSimulatorNotificationHandler(notf *hera.ServerToS
 				Channel: MessagingChannel(entry.MakeVoiceCall.ChannelNumber.Channel),
 			},
 		}
-		s.bus.Publish(string(ElarianMakeVoiceCallSimulatorNotification), s, notification, nil, customer, s.notificationCallBack)
+		s.bus.publish(ctx, ElarianMakeVoiceCallSimulatorNotification, s, notification, nil, customer, cb)
 	}
 }
 
-func (s *elarian) SendMessageSimulatorNotificationHandler(notf *hera.ServerToSimulatorNotification) {
+func (s *elarian) SendMessageSimulatorNotificationHandler(ctx context.Context, notf *hera.ServerToSimulatorNotification, cb NotificationCallBack) {
 	if entry, ok := notf.Entry.(*hera.ServerToSimulatorNotification_SendMessage); ok {
 		customer := &Customer{
 			ID: entry.SendMessage.CustomerId,
@@ -471,6 +452,6 @@ func (s *elarian) SendMessageSimulatorNotificationHandler(notf *hera.ServerToSim
 			},
 		}
 		notification.Message = s.OutboundMessage(entry.SendMessage.Message)
-		s.bus.Publish(string(ElarianSendMessageSimulatorNotification), s, notification, nil, customer, s.notificationCallBack)
+		s.bus.publish(ctx, ElarianSendMessageSimulatorNotification, s, notification, nil, customer, cb)
 	}
 }
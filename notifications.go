@@ -1,6 +1,7 @@
 package elarian
 
 import (
+	"context"
 	"errors"
 	"io"
 	"reflect"
@@ -23,11 +24,15 @@ type (
 
 	// NotificationCallBack is part of the notification handler and should be called after a notification has been handled.
 	// It takes in a message or appData and both can be nil.
-	// These are sent back to elarian. if you the callback is not called in 15 seconds an empty reply is sent back to elarian.
+	// These are sent back to elarian. if you the callback is not called before the reply timeout in Options (15 seconds by default) the default reply is sent back to elarian.
+	// Each notification gets its own callback and only the first call to it is sent.
 	NotificationCallBack func(message IsOutBoundMessageBody, appData *Appdata)
 
 	// NotificationHandler type is a handler function for all notifications. it provides the service, the notification, appdata, customer and the callback handler defined above.
-	NotificationHandler func(service Elarian, notification IsNotification, appData *Appdata, customer *Customer, cb NotificationCallBack)
+	// The context expires with the reply timeout of the notification, is cancelled when the service shuts down and carries the values returned by
+	// EnvelopeFromContext, OrgIDFromContext, AppIDFromContext, CustomerIDFromContext and NotificationFromContext.
+	// If the handler returns an error or panics a HandlerError is sent on the error channel and the default reply is sent back to elarian straight away.
+	NotificationHandler func(ctx context.Context, service Elarian, notification IsNotification, appData *Appdata, customer *Customer, cb NotificationCallBack) error
 
 	// NotificationPaymentStatus defines a structure for a payment status it has a transaction id and a status which is of type payment status
 	NotificationPaymentStatus struct {
@@ -216,8 +221,84 @@ const (
 	ElarianSendCustomerPaymentSimulatorNotification
 	ElarianMakeVoiceCallSimulatorNotification
 	ElarianSendMessageSimulatorNotification
+
+	// ElarianReceivedMessageNotification is published with the whole RecievedMessageNotification for messages received on a channel without its own notification
+	// or on a channel whose notification has no handlers registered, and for messages without a part for their channel's notification.
+	ElarianReceivedMessageNotification
 )
 
+// String returns the name of the notification, such as received_payment
+func (n Notification) String() string {
+	switch n {
+	case ElarianReminderNotification:
+		return "reminder"
+	case ElarianMessageStatusNotification:
+		return "message_status"
+	case ElarianMessagingSessionStartedNotification:
+		return "messaging_session_started"
+	case ElarianMessagingSessionRenewedNotification:
+		return "messaging_session_renewed"
+	case ElarianMessagingSessionEndedNotification:
+		return "messaging_session_ended"
+	case ElarianMessagingConsentUpdateNotification:
+		return "messaging_consent_update"
+	case ElarianReceivedEmailNotification:
+		return "received_email"
+	case ElarianReceivedUssdSessionNotification:
+		return "received_ussd_session"
+	case ElarianReceivedVoiceCallNotification:
+		return "received_voice_call"
+	case ElarianReceivedSmsNotification:
+		return "received_sms"
+	case ElarianReceivedFbMessengerNotification:
+		return "received_fb_messenger"
+	case ElarianReceivedTelegramNotification:
+		return "received_telegram"
+	case ElarianReceivedWhatsappNotification:
+		return "received_whatsapp"
+	case ElarianSentMessageReactionNotification:
+		return "sent_message_reaction"
+	case ElarianReceivedPaymentNotification:
+		return "received_payment"
+	case ElarianPaymentStatusNotification:
+		return "payment_status"
+	case ElarianWalletPaymentStatusNotification:
+		return "wallet_payment_status"
+	case ElarianCustomerActivityNotification:
+		return "customer_activity"
+	case ElarianPaymentPurseNotifiication:
+		return "purse_payment_status"
+	case ElarianSendChannelPaymentSimulatorNotification:
+		return "send_channel_payment"
+	case ElarianCheckoutPaymentSimulatorNotification:
+		return "checkout_payment"
+	case ElarianSendCustomerPaymentSimulatorNotification:
+		return "send_customer_payment"
+	case ElarianMakeVoiceCallSimulatorNotification:
+		return "make_voice_call"
+	case ElarianSendMessageSimulatorNotification:
+		return "send_message"
+	case ElarianReceivedMessageNotification:
+		return "received_message"
+	default:
+		return "unknown"
+	}
+}
+
+// receivedMessagePart returns the part of a received message that is published for the channel notification
+func receivedMessagePart(kind Notification, part *InBoundMessageBody) (IsNotification, bool) {
+	switch kind {
+	case ElarianReceivedUssdSessionNotification:
+		return part.Ussd, part.Ussd != nil
+	case ElarianReceivedEmailNotification:
+		return part.Email, part.Email != nil
+	case ElarianReceivedVoiceCallNotification:
+		return part.Voice, part.Voice != nil
+	default:
+		return part, part != nil
+	}
+}
+
 func (*ReminderNotification) notification()                     {}
 func (*MessageStatusNotification) notification()                {}
 func (*MessageSessionStartedNotification) notification()        {}
@@ -241,7 +322,7 @@ func (*SendCustomerPaymentSimulatorNotification) notification() {}
 func (*MakeVoiceCallSimulatorNotification) notification()       {}
 func (*SendMessageSimulatorNotification) notification()         {}
 
-func (s *elarian) notificationCallBack(body IsOutBoundMessageBody, appData *Appdata) {
+func (s *elarian) notificationReply(body IsOutBoundMessageBody, appData *Appdata) *hera.ServerToAppNotificationReply {
 	reply := new(hera.ServerToAppNotificationReply)
 	if appData != nil && !reflect.ValueOf(appData).IsZero() {
 		reply.DataUpdate = &hera.AppDataUpdate{
@@ -290,72 +371,127 @@ func (s *elarian) notificationCallBack(body IsOutBoundMessageBody, appData *Appd
 		}
 
 	}
-	s.replyChannel <- reply
-}
-
-func (s *elarian) On(notification Notification, handler NotificationHandler) {
-	s.bus.Subscribe(string(notification), handler)
+	return reply
 }
 
 func (s *elarian) handleSimulatorNotification(notf *hera.ServerToSimulatorNotification) {
 	if reflect.ValueOf(notf).IsZero() || reflect.ValueOf(notf.Entry).IsZero() {
 		return
 	}
-	s.SendChannelPaymentSimulatorNotificationHandler(notf)
-	s.CheckoutPaymentSimulatorNotificationHandler(notf)
-	s.SendCustomerPaymentSimulatorNotificationHandler(notf)
-	s.MakeVoiceCallSimulatorNotificationHandler(notf)
-	s.SendMessageSimulatorNotificationHandler(notf)
+	// simulator notifications are replied to as soon as they are received so there is nothing to send a reply to
+	cb := func(message IsOutBoundMessageBody, appData *Appdata) {}
+	// their handlers are called synchronously so their context is cancelled as soon as they return
+	ctx, cancel := context.WithTimeout(notificationContext(s.gate.ctx, &NotificationEnvelope{Simulator: notf}), s.replyTimeout(unknownNotification))
+	defer cancel()
+	s.SendChannelPaymentSimulatorNotificationHandler(ctx, notf, cb)
+	s.CheckoutPaymentSimulatorNotificationHandler(ctx, notf, cb)
+	s.SendCustomerPaymentSimulatorNotificationHandler(ctx, notf, cb)
+	s.MakeVoiceCallSimulatorNotificationHandler(ctx, notf, cb)
+	s.SendMessageSimulatorNotificationHandler(ctx, notf, cb)
 }
 
-func (s *elarian) handleNotifications(notf *hera.ServerToAppNotification) {
+func (s *elarian) handleNotifications(req *notificationRequest) {
+	notf := req.notification
 	if reflect.ValueOf(notf).IsZero() || reflect.ValueOf(notf.Entry).IsZero() {
+		req.sendEmpty()
+		return
+	}
+	envelope := newNotificationEnvelope(notf)
+	ctx := context.WithValue(notificationContext(s.gate.ctx, envelope), requestContextKey, req)
+	if s.duplicate(ctx, req) {
+		req.sendEmpty()
 		return
 	}
+	kind := notificationKind(notf)
+	s.metrics.NotificationReceived(kind)
+	s.logger.Debug("dispatching notification", "notification", kind, "customerId", envelope.CustomerID, "purseId", envelope.PurseID)
+	ctx = s.traceNotification(ctx, req, kind, envelope)
+	ctx, cb := s.replyCallBack(ctx, req, kind, envelope.CustomerID)
 	if customerNotf, ok := notf.Entry.(*hera.ServerToAppNotification_Customer); ok {
 		if reflect.ValueOf(customerNotf.Customer).IsZero() {
+			req.sendEmpty()
 			return
 		}
-		s.reminderNotificationHandler(customerNotf.Customer)
-		s.messageStatusNotificationHandler(customerNotf.Customer)
-		s.messagingSessionStartedNotificationHandler(customerNotf.Customer)
-		s.messagingSessionRenewedNotificationHandler(customerNotf.Customer)
-		s.messagingSessionEndedNotificationHandler(customerNotf.Customer)
-		s.messagingConsentUpdateNotificationHandler(customerNotf.Customer)
-		s.recievedMessageNotificationHandler(customerNotf.Customer)
-		s.sentMesssageNotificationHandler(customerNotf.Customer)
-		s.receivedPaymentNotificationHandler(customerNotf.Customer)
-		s.paymentStatusNotificationHandler(customerNotf.Customer)
-		s.walletPaymentStatusNotificationHandler(customerNotf.Customer)
-		s.customerActivityNotificationHandler(customerNotf.Customer)
+		s.reminderNotificationHandler(ctx, customerNotf.Customer, cb)
+		s.messageStatusNotificationHandler(ctx, customerNotf.Customer, cb)
+		s.messagingSessionStartedNotificationHandler(ctx, customerNotf.Customer, cb)
+		s.messagingSessionRenewedNotificationHandler(ctx, customerNotf.Customer, cb)
+		s.messagingSessionEndedNotificationHandler(ctx, customerNotf.Customer, cb)
+		s.messagingConsentUpdateNotificationHandler(ctx, customerNotf.Customer, cb)
+		s.recievedMessageNotificationHandler(ctx, customerNotf.Customer, cb)
+		s.sentMesssageNotificationHandler(ctx, customerNotf.Customer, cb)
+		s.receivedPaymentNotificationHandler(ctx, customerNotf.Customer, cb)
+		s.paymentStatusNotificationHandler(ctx, customerNotf.Customer, cb)
+		s.walletPaymentStatusNotificationHandler(ctx, customerNotf.Customer, cb)
+		s.customerActivityNotificationHandler(ctx, customerNotf.Customer, cb)
 		return
 	}
 
 	if purseNotification, ok := notf.Entry.(*hera.ServerToAppNotification_Purse); ok {
-		s.paymentPurseStatusNotificationHandler(purseNotification)
+		s.paymentPurseStatusNotificationHandler(ctx, purseNotification, cb)
 		return
 	}
 }
 
 func (s *elarian) InitializeNotificationStream() <-chan error {
-	errorChan := make(chan error)
+	errorChan := make(chan error, errorChannelSize)
+	s.pool.start(s.gate.ctx)
+	s.replayOnce.Do(func() {
+		go s.replay(s.replays)
+	})
 	go func() {
+		defer close(errorChan)
 		for {
 			select {
-			case notification := <-s.notificationChannel:
-				s.handleNotifications(notification)
-			case simulatorNotification := <-s.simulatorNotificationChannel:
-				s.handleSimulatorNotification(simulatorNotification)
 			case err := <-s.errorChannel:
 				if errors.Is(err, io.EOF) {
-					close(s.replyChannel)
 					return
 				}
 				if err != nil {
 					errorChan <- err
 				}
+			case <-s.gate.ctx.Done():
+				s.forwardErrors(errorChan)
+				return
 			}
 		}
 	}()
 	return errorChan
 }
+
+// forwardErrors hands the errors reported before the connection closed to errorChan without blocking
+func (s *elarian) forwardErrors(errorChan chan<- error) {
+	for {
+		select {
+		case err := <-s.errorChannel:
+			select {
+			case errorChan <- err:
+			default:
+			}
+		default:
+			return
+		}
+	}
+}
+
+// receivedMessageKind returns the received message notification for a messaging channel, ElarianReceivedMessageNotification is returned for channels without their own notification
+func receivedMessageKind(channel MessagingChannel) Notification {
+	switch channel {
+	case MessagingChannelUssd:
+		return ElarianReceivedUssdSessionNotification
+	case MessagingChannelEmail:
+		return ElarianReceivedEmailNotification
+	case MessagingChannelVoice:
+		return ElarianReceivedVoiceCallNotification
+	case MessagingChannelTelegram:
+		return ElarianReceivedTelegramNotification
+	case MessagingChannelWhatsapp:
+		return ElarianReceivedWhatsappNotification
+	case MessagingChannelFBMessanger:
+		return ElarianReceivedFbMessengerNotification
+	case MessagingChannelSms:
+		return ElarianReceivedSmsNotification
+	default:
+		return ElarianReceivedMessageNotification
+	}
+}
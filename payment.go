@@ -6,8 +6,6 @@ import (
 	"reflect"
 
 	hera "github.com/elarianltd/go-sdk/com_elarian_hera_proto"
-	"github.com/rsocket/rsocket-go/payload"
-	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/wrapperspb"
 )
 
@@ -227,19 +225,10 @@ func (s *elarian) InitiatePayment(ctx context.Context, party *PaymentCounterPart
 	req := &hera.AppToServerCommand{
 		Entry: &hera.AppToServerCommand_InitiatePayment{InitiatePayment: command},
 	}
-	data, err := proto.Marshal(req)
+	commandReply, err := s.sendCommand(ctx, req)
 	if err != nil {
 		return nil, err
 	}
-	res, err := s.client.RequestResponse(payload.New(data, []byte{})).Block(ctx)
-	if err != nil {
-		return nil, err
-	}
-
-	commandReply := &hera.AppToServerCommandReply{}
-	if err = proto.Unmarshal(res.Data(), commandReply); err != nil {
-		return nil, err
-	}
 	paymentReply := commandReply.GetInitiatePayment()
 	reply := &InitiatePaymentReply{
 		Status:        PaymentStatus(paymentReply.Status),
@@ -279,18 +268,10 @@ func (s *elarian) ReceivePayment(ctx context.Context, customerNumber, transactio
 	req := &hera.SimulatorToServerCommand{
 		Entry: &hera.SimulatorToServerCommand_ReceivePayment{ReceivePayment: command},
 	}
-	data, err := proto.Marshal(req)
-	if err != nil {
-		return nil, err
-	}
-	payload, err := s.client.RequestResponse(payload.New(data, []byte{})).Block(ctx)
+	reply, err := s.sendSimulatorCommand(ctx, req)
 	if err != nil {
 		return nil, err
 	}
-	reply := new(hera.SimulatorToServerCommandReply)
-	if err = proto.Unmarshal(payload.Data(), reply); err != nil {
-		return nil, err
-	}
 	return &SimulatorToServerCommandReply{
 		Status:      reply.Status,
 		Description: reply.Description,
@@ -306,18 +287,10 @@ func (s *elarian) UpdatePaymentStatus(ctx context.Context, transactionID string,
 	req := &hera.SimulatorToServerCommand{
 		Entry: &hera.SimulatorToServerCommand_UpdatePaymentStatus{UpdatePaymentStatus: command},
 	}
-	data, err := proto.Marshal(req)
-	if err != nil {
-		return nil, err
-	}
-	payload, err := s.client.RequestResponse(payload.New(data, []byte{})).Block(ctx)
+	reply, err := s.sendSimulatorCommand(ctx, req)
 	if err != nil {
 		return nil, err
 	}
-	reply := new(hera.SimulatorToServerCommandReply)
-	if err = proto.Unmarshal(payload.Data(), reply); err != nil {
-		return nil, err
-	}
 	return &SimulatorToServerCommandReply{
 		Status:      reply.Status,
 		Message:     s.OutboundMessage(reply.Message),
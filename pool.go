@@ -0,0 +1,216 @@
+package elarian
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"runtime/debug"
+	"sync"
+
+	hera "github.com/elarianltd/go-sdk/com_elarian_hera_proto"
+)
+
+type (
+	// BackpressurePolicy decides what happens to a notification that arrives while the queue of the worker it is assigned to is full
+	BackpressurePolicy int32
+
+	// notificationJob is a notification waiting to be handled by a worker. Exactly one of request and simulator is set
+	notificationJob struct {
+		key       string
+		request   *notificationRequest
+		simulator *hera.ServerToSimulatorNotification
+	}
+
+	// notificationPool handles notifications on a fixed number of workers.
+	// Jobs with the same key are always queued on the same worker so they are handled in the order they were received.
+	notificationPool struct {
+		queues    []chan *notificationJob
+		policy    BackpressurePolicy
+		handle    func(job *notificationJob)
+		overflow  func(job *notificationJob)
+		release   func(job *notificationJob)
+		startOnce sync.Once
+	}
+)
+
+// BackpressurePolicy constants
+const (
+	// BackpressureBlock waits for the queue to have room, elarian does not get a reply until the notification is queued
+	BackpressureBlock BackpressurePolicy = iota
+
+	// BackpressureDefaultReply replies with the default reply straight away without calling any handlers.
+	// Simulator notifications are dropped instead and reported on the error channel
+	BackpressureDefaultReply
+
+	// BackpressureReject fails the notification so that elarian can deliver it again later
+	BackpressureReject
+)
+
+const (
+	defaultWorkers   int = 1
+	defaultQueueSize int = 64
+)
+
+var (
+	errQueueFull = errors.New("notification queue is full")
+
+	// errSimulatorOverflow is reported for simulator notifications that overflow their queue, they were already replied to so they are dropped
+	errSimulatorOverflow = fmt.Errorf("dropped simulator notification without calling its handlers: %w", errQueueFull)
+)
+
+func newNotificationPool(options *Options) *notificationPool {
+	workers, queueSize := options.Workers, options.QueueSize
+	if workers <= 0 {
+		workers = defaultWorkers
+	}
+	if queueSize <= 0 {
+		queueSize = defaultQueueSize
+	}
+	pool := &notificationPool{
+		queues: make([]chan *notificationJob, workers),
+		policy: options.Backpressure,
+	}
+	for i := range pool.queues {
+		pool.queues[i] = make(chan *notificationJob, queueSize)
+	}
+	return pool
+}
+
+// start starts the workers once, they stop when ctx is done
+func (p *notificationPool) start(ctx context.Context) {
+	p.startOnce.Do(func() {
+		for _, queue := range p.queues {
+			go p.work(ctx, queue, p.handle)
+		}
+	})
+}
+
+// drain starts workers that release the queued jobs instead of handling them, unless the workers were already started.
+// It keeps the jobs queued before the notification stream was initialized from holding up shutdown.
+func (p *notificationPool) drain(ctx context.Context) {
+	p.startOnce.Do(func() {
+		for _, queue := range p.queues {
+			go p.work(ctx, queue, p.release)
+		}
+	})
+}
+
+func (p *notificationPool) work(ctx context.Context, queue <-chan *notificationJob, handle func(job *notificationJob)) {
+	for {
+		select {
+		case job := <-queue:
+			handle(job)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// submit queues a job on the worker its key is assigned to and applies the backpressure policy when that queue is full.
+// It returns errQueueFull when the job is rejected and ctx's error when ctx is done before the job is queued.
+func (p *notificationPool) submit(ctx context.Context, job *notificationJob) error {
+	queue := p.queues[p.worker(job.key)]
+	select {
+	case queue <- job:
+		return nil
+	default:
+	}
+
+	switch p.policy {
+	case BackpressureDefaultReply:
+		p.overflow(job)
+		return nil
+	case BackpressureReject:
+		return errQueueFull
+	default:
+		return p.enqueue(ctx, job)
+	}
+}
+
+// enqueue waits for the queue of the worker the job's key is assigned to to have room, it returns ctx's error when ctx is done first
+func (p *notificationPool) enqueue(ctx context.Context, job *notificationJob) error {
+	select {
+	case p.queues[p.worker(job.key)] <- job:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *notificationPool) worker(key string) int {
+	if key == "" || len(p.queues) == 1 {
+		return 0
+	}
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(key))
+	return int(hash.Sum32() % uint32(len(p.queues)))
+}
+
+// notificationKey returns the key that orders notifications, notifications about the same customer or purse share a key
+func notificationKey(notf *hera.ServerToAppNotification) string {
+	switch entry := notf.GetEntry().(type) {
+	case *hera.ServerToAppNotification_Customer:
+		return entry.Customer.GetCustomerId()
+	case *hera.ServerToAppNotification_Purse:
+		return entry.Purse.GetPurseId()
+	default:
+		return ""
+	}
+}
+
+// handleJob is called by the workers of the pool, a panic while handling the job is reported instead of stopping the worker
+func (s *elarian) handleJob(job *notificationJob) {
+	defer s.gate.leave()
+	defer s.recoverJob(job)
+	if job.request != nil {
+		defer s.forgetUnanswered(job.request)
+		defer job.request.handled()
+		s.handleNotifications(job.request)
+		return
+	}
+	s.handleSimulatorNotification(job.simulator)
+}
+
+// overflowJob is called instead of handleJob for jobs that arrive while their queue is full and the backpressure policy is BackpressureDefaultReply
+func (s *elarian) overflowJob(job *notificationJob) {
+	defer s.gate.leave()
+	if job.request == nil {
+		s.logger.Warn("dropped simulator notification, the notification queue is full")
+		s.reportError(errSimulatorOverflow)
+		return
+	}
+	notf := job.request.notification
+	s.sendDefaultReply(job.request, notificationKind(notf), notf.GetCustomer().GetCustomerId())
+	job.request.handled()
+}
+
+// releaseJob is called instead of handleJob for jobs still queued when the service shuts down before the notification stream was initialized.
+// Notifications elarian is waiting for get the default reply, replayed notifications stay in the journal.
+func (s *elarian) releaseJob(job *notificationJob) {
+	defer s.gate.leave()
+	if job.request == nil || job.request.replayed {
+		return
+	}
+	notf := job.request.notification
+	kind, customerID := notificationKind(notf), notf.GetCustomer().GetCustomerId()
+	s.sendDefaultReply(job.request, kind, customerID)
+	job.request.handled()
+	s.logger.Warn("sent the default reply to a notification received before the notification stream was initialized", "notification", kind, "customerId", customerID)
+}
+
+// recoverJob reports a panic raised while handling a job outside of the notification handlers and sends the default reply
+func (s *elarian) recoverJob(job *notificationJob) {
+	value := recover()
+	if value == nil {
+		return
+	}
+	handlerErr := &HandlerError{Notification: unknownNotification, Err: newPanicError(value), Panic: value, Stack: debug.Stack()}
+	if job.request != nil {
+		notf := job.request.notification
+		handlerErr.Notification, handlerErr.CustomerID = notificationKind(notf), notf.GetCustomer().GetCustomerId()
+		s.sendDefaultReply(job.request, handlerErr.Notification, handlerErr.CustomerID)
+		s.forget(job.request)
+	}
+	s.reportError(handlerErr)
+}
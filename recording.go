@@ -0,0 +1,385 @@
+package elarian
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	hera "github.com/elarianltd/go-sdk/com_elarian_hera_proto"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+type (
+	// RecordFormat is the encoding notification frames are recorded in
+	RecordFormat int32
+
+	// RecordedNotification is a notification frame recorded with Options.RecordPath.
+	// Frame is the ServerToAppNotification, or the ServerToSimulatorNotification when Simulator is set, in the protobuf JSON encoding,
+	// whichever format it was recorded in. Entry names the notification in the frame, such as customer.received_payment
+	RecordedNotification struct {
+		ReceivedAt time.Time       `json:"receivedAt"`
+		Simulator  bool            `json:"simulator,omitempty"`
+		Entry      string          `json:"entry,omitempty"`
+		CustomerID string          `json:"customerId,omitempty"`
+		Frame      json.RawMessage `json:"frame"`
+	}
+
+	// recorder appends every notification frame received from elarian to a file in its format
+	recorder struct {
+		mu     sync.Mutex
+		file   *os.File
+		format RecordFormat
+	}
+
+	// Replayer is a service that is not connected to elarian, it feeds recorded notifications to the handlers registered on it.
+	// Commands sent through it fail with ErrUnreachable.
+	Replayer struct {
+		Elarian
+		service *elarian
+	}
+)
+
+// RecordFormat constants
+const (
+	// RecordFormatJSON records one RecordedNotification per line in JSON, with the frame in the protobuf JSON encoding
+	RecordFormatJSON RecordFormat = iota
+
+	// RecordFormatProtobuf records the binary protobuf frames, each in a length prefixed record after a header that identifies the format.
+	// It is smaller and faster to write than RecordFormatJSON and keeps fields that the JSON encoding cannot represent
+	RecordFormatProtobuf
+)
+
+// recordingHeader starts recordings in RecordFormatProtobuf, JSON recordings cannot start with a zero byte
+const recordingHeader string = "\x00elarian-recording-protobuf\n"
+
+// the fields of a record in RecordFormatProtobuf
+const (
+	recordReceivedAt protowire.Number = iota + 1
+	recordSimulator
+	recordCustomerID
+	recordFrame
+)
+
+func openRecorder(path string, format RecordFormat) (*recorder, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("opening recording: %w", err)
+	}
+	existing, empty, err := recordingFormat(bufio.NewReader(file))
+	if err == nil && !empty && existing != format {
+		err = fmt.Errorf("recording %s is in another format", path)
+	}
+	if err == nil && empty && format == RecordFormatProtobuf {
+		_, err = file.WriteString(recordingHeader)
+	}
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("opening recording: %w", err)
+	}
+	return &recorder{file: file, format: format}, nil
+}
+
+// recordingFormat detects the format of a recording from its first bytes, empty is set for recordings that hold nothing yet
+func recordingFormat(reader *bufio.Reader) (format RecordFormat, empty bool, err error) {
+	start, err := reader.Peek(len(recordingHeader))
+	if err != nil && err != io.EOF {
+		return 0, false, err
+	}
+	switch {
+	case len(start) == 0:
+		return RecordFormatJSON, true, nil
+	case string(start) == recordingHeader:
+		_, err = reader.Discard(len(recordingHeader))
+		return RecordFormatProtobuf, false, err
+	case start[0] == recordingHeader[0]:
+		return 0, false, errors.New("unknown recording format")
+	default:
+		return RecordFormatJSON, false, nil
+	}
+}
+
+// record appends a frame to the recording
+func (r *recorder) record(simulator bool, frame proto.Message, customerID string) error {
+	var (
+		record []byte
+		err    error
+	)
+	if r.format == RecordFormatProtobuf {
+		record, err = protobufRecord(time.Now(), simulator, frame, customerID)
+	} else {
+		record, err = jsonRecord(time.Now(), simulator, frame, customerID)
+	}
+	if err != nil {
+		return fmt.Errorf("recording notification: %w", err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return os.ErrClosed
+	}
+	if _, err := r.file.Write(record); err != nil {
+		return fmt.Errorf("recording notification: %w", err)
+	}
+	return nil
+}
+
+// jsonRecord encodes a frame as a line of a recording in RecordFormatJSON
+func jsonRecord(receivedAt time.Time, simulator bool, frame proto.Message, customerID string) ([]byte, error) {
+	data, err := protojson.Marshal(frame)
+	if err != nil {
+		return nil, err
+	}
+	line, err := json.Marshal(&RecordedNotification{
+		ReceivedAt: receivedAt,
+		Simulator:  simulator,
+		Entry:      entryName(frame.ProtoReflect()),
+		CustomerID: customerID,
+		Frame:      data,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append(line, '\n'), nil
+}
+
+// protobufRecord encodes a frame as a length prefixed record of a recording in RecordFormatProtobuf
+func protobufRecord(receivedAt time.Time, simulator bool, frame proto.Message, customerID string) ([]byte, error) {
+	data, err := proto.Marshal(frame)
+	if err != nil {
+		return nil, err
+	}
+	record := protowire.AppendTag(nil, recordReceivedAt, protowire.VarintType)
+	record = protowire.AppendVarint(record, uint64(receivedAt.UnixNano()))
+	if simulator {
+		record = protowire.AppendTag(record, recordSimulator, protowire.VarintType)
+		record = protowire.AppendVarint(record, protowire.EncodeBool(simulator))
+	}
+	if customerID != "" {
+		record = protowire.AppendTag(record, recordCustomerID, protowire.BytesType)
+		record = protowire.AppendString(record, customerID)
+	}
+	record = protowire.AppendTag(record, recordFrame, protowire.BytesType)
+	record = protowire.AppendBytes(record, data)
+	return protowire.AppendBytes(nil, record), nil
+}
+
+// readProtobufRecord decodes a record of a recording in RecordFormatProtobuf, the frame is converted to the protobuf JSON encoding
+func readProtobufRecord(record []byte) (*RecordedNotification, error) {
+	recorded := new(RecordedNotification)
+	var data []byte
+	for len(record) > 0 {
+		number, kind, n := protowire.ConsumeTag(record)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		record = record[n:]
+		switch {
+		case number == recordReceivedAt && kind == protowire.VarintType:
+			var value uint64
+			value, n = protowire.ConsumeVarint(record)
+			recorded.ReceivedAt = time.Unix(0, int64(value))
+		case number == recordSimulator && kind == protowire.VarintType:
+			var value uint64
+			value, n = protowire.ConsumeVarint(record)
+			recorded.Simulator = protowire.DecodeBool(value)
+		case number == recordCustomerID && kind == protowire.BytesType:
+			var value []byte
+			value, n = protowire.ConsumeBytes(record)
+			recorded.CustomerID = string(value)
+		case number == recordFrame && kind == protowire.BytesType:
+			data, n = protowire.ConsumeBytes(record)
+		default:
+			n = protowire.ConsumeFieldValue(number, kind, record)
+		}
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		record = record[n:]
+	}
+
+	var frame proto.Message = new(hera.ServerToAppNotification)
+	if recorded.Simulator {
+		frame = new(hera.ServerToSimulatorNotification)
+	}
+	if err := proto.Unmarshal(data, frame); err != nil {
+		return nil, err
+	}
+	recorded.Entry = entryName(frame.ProtoReflect())
+	var err error
+	recorded.Frame, err = protojson.Marshal(frame)
+	return recorded, err
+}
+
+func (r *recorder) close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+// entryName follows the entry oneofs of a frame down to the notification it holds and joins their field names
+func entryName(message protoreflect.Message) string {
+	var names []string
+	for message != nil {
+		oneof := message.Descriptor().Oneofs().ByName("entry")
+		if oneof == nil {
+			break
+		}
+		field := message.WhichOneof(oneof)
+		if field == nil {
+			break
+		}
+		names = append(names, string(field.Name()))
+		if field.Message() == nil {
+			break
+		}
+		message = message.Get(field).Message()
+	}
+	return strings.Join(names, ".")
+}
+
+// recorded records a frame received by the acceptor, errors are reported and the frame is handled anyway
+func (s *service) recorded(simulator bool, frame proto.Message, customerID string) {
+	if s.recorder == nil {
+		return
+	}
+	if err := s.recorder.record(simulator, frame, customerID); err != nil && !errors.Is(err, os.ErrClosed) {
+		s.reportError(err)
+	}
+}
+
+// ReadRecording reads the notifications recorded at path in the order they were received, the format of the recording is detected
+func ReadRecording(path string) ([]*RecordedNotification, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening recording: %w", err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	format, _, err := recordingFormat(reader)
+	if err != nil {
+		return nil, fmt.Errorf("reading recording: %w", err)
+	}
+	if format == RecordFormatProtobuf {
+		return readProtobufRecording(reader)
+	}
+	return readJSONRecording(reader)
+}
+
+func readJSONRecording(reader io.Reader) ([]*RecordedNotification, error) {
+	var recorded []*RecordedNotification
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		notification := new(RecordedNotification)
+		if err := json.Unmarshal(scanner.Bytes(), notification); err != nil {
+			return nil, fmt.Errorf("reading recording line %d: %w", line, err)
+		}
+		recorded = append(recorded, notification)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading recording: %w", err)
+	}
+	return recorded, nil
+}
+
+func readProtobufRecording(reader *bufio.Reader) ([]*RecordedNotification, error) {
+	var recorded []*RecordedNotification
+	for i := 1; ; i++ {
+		size, err := binary.ReadUvarint(reader)
+		if err == io.EOF {
+			return recorded, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading recording record %d: %w", i, err)
+		}
+		var record bytes.Buffer
+		if _, err := io.CopyN(&record, reader, int64(size)); err != nil {
+			return nil, fmt.Errorf("reading recording record %d: %w", i, err)
+		}
+		notification, err := readProtobufRecord(record.Bytes())
+		if err != nil {
+			return nil, fmt.Errorf("reading recording record %d: %w", i, err)
+		}
+		recorded = append(recorded, notification)
+	}
+}
+
+// NewReplayer returns a Replayer that dispatches notifications like a service created with the same options would.
+// Options that need elarian, such as the credentials, and the options that record or journal notifications are ignored.
+// Errors, such as the ones returned by handlers, are sent on the channel returned by InitializeNotificationStream
+func NewReplayer(options *Options) *Replayer {
+	offline := *options
+	offline.RecordPath, offline.JournalPath = "", ""
+	// newService only fails to open the recording and the journal
+	_, service, _ := newService(&offline)
+	service.client = newOfflineConnection()
+	service.client.onClosed = service.gate.finish
+	service.commands.transport = service.client
+	return &Replayer{Elarian: service, service: service}
+}
+
+// Replay feeds the notifications recorded at path to the handlers in the order they were received.
+// Each notification is handled once the previous one is replied to, the replies are logged to Options.Logger and otherwise discarded.
+func (r *Replayer) Replay(ctx context.Context, path string) error {
+	recorded, err := ReadRecording(path)
+	if err != nil {
+		return err
+	}
+	for i, notification := range recorded {
+		if err := r.replay(ctx, notification); err != nil {
+			return fmt.Errorf("replaying notification %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+func (r *Replayer) replay(ctx context.Context, recorded *RecordedNotification) error {
+	if recorded.Simulator {
+		notification := new(hera.ServerToSimulatorNotification)
+		if err := protojson.Unmarshal(recorded.Frame, notification); err != nil {
+			return err
+		}
+		r.service.handleSimulatorNotification(notification)
+		return nil
+	}
+
+	notification := new(hera.ServerToAppNotification)
+	if err := protojson.Unmarshal(recorded.Frame, notification); err != nil {
+		return err
+	}
+	req := newNotificationRequest(notification)
+	// replayed notifications are not deduplicated, the recording may hold notifications the store has already seen
+	req.replayed = true
+	if !r.service.gate.enter(1) {
+		return errShuttingDown
+	}
+	r.service.handleJob(&notificationJob{request: req})
+	select {
+	case reply := <-req.reply:
+		r.service.logger.Info("replayed notification", "entry", recorded.Entry, "customerId", recorded.CustomerID, "reply", protojson.Format(reply))
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,246 @@
+package elarian
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	hera "github.com/elarianltd/go-sdk/com_elarian_hera_proto"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type (
+	// DefaultReplyFunc builds the reply sent back to elarian when a notification is not replied to before its timeout.
+	// It can return a nil message and appData to send an empty reply.
+	DefaultReplyFunc func(notification Notification, customerID string) (message IsOutBoundMessageBody, appData *Appdata)
+
+	// ReplyTimeoutHandler is called every time a notification is not replied to before its timeout
+	ReplyTimeoutHandler func(notification Notification, customerID string, timeout time.Duration)
+
+	// notificationRequest pairs a notification received from elarian with the channel its reply is sent back on.
+	// onComplete is called once the handlers reply to the notification, even after the reply timeout, or once the notification was replied to and its handlers returned.
+	// replayed is set for notifications replayed from the journal and dedupKey to the key the notification was added to the dedup store with.
+	// answered is set once a handler calls the callback, even after the reply timeout, and defaulted once the default reply is sent on timeout.
+	// parent is the trace context elarian sent with the notification and span the span it is handled in, metadata carries the trace context of the reply back to elarian
+	notificationRequest struct {
+		notification *hera.ServerToAppNotification
+		received     time.Time
+		reply        chan *hera.ServerToAppNotificationReply
+		once         sync.Once
+		mu           sync.Mutex
+		timer        *time.Timer
+		replied      bool
+		returned     bool
+		answered     bool
+		defaulted    bool
+		dedupKey     string
+		completeOnce sync.Once
+		onComplete   func()
+		replayed     bool
+		parent       trace.SpanContext
+		span         trace.Span
+		tracer       trace.Tracer
+		propagator   propagation.TextMapPropagator
+		metadata     []byte
+	}
+)
+
+const defaultReplyTimeout time.Duration = time.Second * 15
+
+// unknownNotification is the kind of customer notifications this version of the sdk does not handle
+const unknownNotification Notification = -1
+
+func newNotificationRequest(notification *hera.ServerToAppNotification) *notificationRequest {
+	return &notificationRequest{
+		notification: notification,
+		received:     time.Now(),
+		reply:        make(chan *hera.ServerToAppNotificationReply, 1),
+		metadata:     []byte{},
+	}
+}
+
+// send sends the first reply to the request, later replies are ignored. source names what the reply came from on the reply's span
+func (r *notificationRequest) send(source string, reply func() *hera.ServerToAppNotificationReply) bool {
+	sent := false
+	r.once.Do(func() {
+		r.mu.Lock()
+		if r.timer != nil {
+			r.timer.Stop()
+		}
+		span, tracer := r.span, r.tracer
+		r.mu.Unlock()
+		if span != nil {
+			ctx, replySpan := tracer.Start(trace.ContextWithSpan(context.Background(), span), "elarian notification reply",
+				trace.WithSpanKind(trace.SpanKindProducer), trace.WithAttributes(attributeReply.String(source)))
+			r.metadata = injectMetadata(ctx, r.propagator)
+			replySpan.End()
+		}
+		r.reply <- reply()
+		sent = true
+	})
+	if sent {
+		r.mu.Lock()
+		r.replied = true
+		returned := r.returned
+		r.mu.Unlock()
+		if returned {
+			r.complete()
+		}
+	}
+	return sent
+}
+
+// handled is called once the handlers of the request return, the request is completed if it was already replied to
+func (r *notificationRequest) handled() {
+	r.mu.Lock()
+	r.returned = true
+	replied := r.replied
+	r.mu.Unlock()
+	if replied {
+		r.complete()
+	}
+}
+
+// complete marks the notification as handled and ends its span
+func (r *notificationRequest) complete() {
+	r.completeOnce.Do(func() {
+		if r.onComplete != nil {
+			r.onComplete()
+		}
+		r.mu.Lock()
+		span := r.span
+		r.mu.Unlock()
+		if span != nil {
+			span.End()
+		}
+	})
+}
+
+// sendEmpty replies to a notification that has nothing to dispatch
+func (r *notificationRequest) sendEmpty() {
+	r.send("empty", func() *hera.ServerToAppNotificationReply {
+		return new(hera.ServerToAppNotificationReply)
+	})
+	r.complete()
+}
+
+// replyTimeout returns how long handlers have to reply to a notification
+func (s *elarian) replyTimeout(kind Notification) time.Duration {
+	if timeout, ok := s.replyTimeouts[kind]; ok && timeout > 0 {
+		return timeout
+	}
+	if s.defaultReplyTimeout > 0 {
+		return s.defaultReplyTimeout
+	}
+	return defaultReplyTimeout
+}
+
+// replyCallBack returns the context and callback handed to the handlers of a single notification.
+// The first call to the callback sends its reply back to the request that delivered the notification, later calls are ignored.
+// If no reply is sent before the reply timeout of the notification the default reply is sent instead. The context expires at the same time.
+func (s *elarian) replyCallBack(ctx context.Context, req *notificationRequest, kind Notification, customerID string) (context.Context, NotificationCallBack) {
+	timeout := s.replyTimeout(kind)
+	ctx, cancel := context.WithDeadline(ctx, req.received.Add(timeout))
+	req.mu.Lock()
+	defer req.mu.Unlock()
+	req.timer = time.AfterFunc(timeout-time.Since(req.received), func() {
+		defer cancel()
+		if !s.sendDefaultReply(req, kind, customerID) {
+			return
+		}
+		req.mu.Lock()
+		req.defaulted = true
+		req.mu.Unlock()
+		s.forgetUnanswered(req)
+		s.metrics.ReplyTimedOut(kind)
+		s.logger.Warn("notification reply timed out", "notification", kind, "customerId", customerID, "timeout", timeout)
+		if s.onReplyTimeout != nil {
+			s.onReplyTimeout(kind, customerID, timeout)
+		}
+	})
+	return ctx, func(body IsOutBoundMessageBody, appData *Appdata) {
+		req.mu.Lock()
+		req.answered = true
+		req.mu.Unlock()
+		req.send("handler", func() *hera.ServerToAppNotificationReply {
+			return s.notificationReply(body, appData)
+		})
+		req.complete()
+	}
+}
+
+// sendDefaultReply replies to a notification with the default reply, it returns false if the notification was already replied to
+func (s *elarian) sendDefaultReply(req *notificationRequest, kind Notification, customerID string) bool {
+	return req.send("default", func() *hera.ServerToAppNotificationReply {
+		if s.defaultReply == nil {
+			return new(hera.ServerToAppNotificationReply)
+		}
+		return s.notificationReply(s.defaultReply(kind, customerID))
+	})
+}
+
+// forgetUnanswered removes a notification from the dedup store once the default reply was sent on timeout and its handlers returned without replying.
+// Handlers that are still running when the timeout fires may yet handle the notification, so it is not forgotten on timeout alone
+func (s *elarian) forgetUnanswered(req *notificationRequest) {
+	req.mu.Lock()
+	unanswered := req.defaulted && req.returned && !req.answered
+	req.mu.Unlock()
+	if unanswered {
+		s.forget(req)
+	}
+}
+
+// handlerFailed reports a handler that returned an error or panicked, removes its notification from the dedup store and sends the default reply
+// without waiting for the reply timeout
+func (s *elarian) handlerFailed(ctx context.Context, err *HandlerError, cb NotificationCallBack) {
+	s.logger.Error("notification handler failed", "notification", err.Notification, "customerId", err.CustomerID, "error", err.Err)
+	s.reportError(err)
+	if req, ok := requestFromContext(ctx); ok {
+		s.forget(req)
+	}
+	if s.defaultReply == nil {
+		cb(nil, nil)
+		return
+	}
+	cb(s.defaultReply(err.Notification, err.CustomerID))
+}
+
+// notificationKind returns the kind of notification a handler is published for
+func notificationKind(notf *hera.ServerToAppNotification) Notification {
+	if _, ok := notf.Entry.(*hera.ServerToAppNotification_Purse); ok {
+		return ElarianPaymentPurseNotifiication
+	}
+	customerNotf, ok := notf.Entry.(*hera.ServerToAppNotification_Customer)
+	if !ok {
+		return unknownNotification
+	}
+	switch entry := customerNotf.Customer.GetEntry().(type) {
+	case *hera.ServerToAppCustomerNotification_Reminder:
+		return ElarianReminderNotification
+	case *hera.ServerToAppCustomerNotification_MessageStatus:
+		return ElarianMessageStatusNotification
+	case *hera.ServerToAppCustomerNotification_MessagingSessionStarted:
+		return ElarianMessagingSessionStartedNotification
+	case *hera.ServerToAppCustomerNotification_MessagingSessionRenewed:
+		return ElarianMessagingSessionRenewedNotification
+	case *hera.ServerToAppCustomerNotification_MessagingSessionEnded:
+		return ElarianMessagingSessionEndedNotification
+	case *hera.ServerToAppCustomerNotification_MessagingConsentUpdate:
+		return ElarianMessagingConsentUpdateNotification
+	case *hera.ServerToAppCustomerNotification_ReceivedMessage:
+		return receivedMessageKind(MessagingChannel(entry.ReceivedMessage.GetChannelNumber().GetChannel()))
+	case *hera.ServerToAppCustomerNotification_SentMessageReaction:
+		return ElarianSentMessageReactionNotification
+	case *hera.ServerToAppCustomerNotification_ReceivedPayment:
+		return ElarianReceivedPaymentNotification
+	case *hera.ServerToAppCustomerNotification_PaymentStatus:
+		return ElarianPaymentStatusNotification
+	case *hera.ServerToAppCustomerNotification_WalletPaymentStatus:
+		return ElarianWalletPaymentStatusNotification
+	case *hera.ServerToAppCustomerNotification_CustomerActivity:
+		return ElarianCustomerActivityNotification
+	default:
+		return unknownNotification
+	}
+}
@@ -0,0 +1,149 @@
+package elarian
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/rsocket/rsocket-go/core"
+)
+
+type (
+	// RetryPolicy decides when a command that failed is sent again.
+	// Commands that are not idempotent, such as send_message and initiate_payment, are only sent again when elarian is known not to have received them:
+	// when the sdk was not connected or elarian rejected the command without processing it
+	RetryPolicy struct {
+		// MaxAttempts is the most times a command is sent including the first attempt, retries are disabled when it is less than 2
+		MaxAttempts int `json:"maxAttempts,omitempty"`
+
+		// Backoff is the delay before the first retry, it defaults to 100 milliseconds.
+		// It doubles with every retry up to MaxBackoff, which defaults to 2 seconds, and half of it is randomized.
+		Backoff    time.Duration `json:"backoff,omitempty"`
+		MaxBackoff time.Duration `json:"maxBackoff,omitempty"`
+
+		// Retryable reports whether a command that failed with err may be sent again, it defaults to IsRetryable
+		Retryable func(err error) bool `json:"-"`
+	}
+
+	// retrier applies a RetryPolicy to the commands sent by the executor
+	retrier struct {
+		maxAttempts int
+		backoff     time.Duration
+		maxBackoff  time.Duration
+		retryable   func(err error) bool
+
+		mu   sync.Mutex
+		rand *rand.Rand
+	}
+)
+
+// newRetrier returns the retrier for Options.RetryPolicy, commands are never retried when it is nil
+func newRetrier(policy *RetryPolicy) *retrier {
+	r := &retrier{
+		maxAttempts: 1,
+		backoff:     time.Millisecond * 100,
+		maxBackoff:  time.Second * 2,
+		retryable:   IsRetryable,
+		rand:        rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	if policy == nil {
+		return r
+	}
+	if policy.MaxAttempts > 1 {
+		r.maxAttempts = policy.MaxAttempts
+	}
+	if policy.Backoff > 0 {
+		r.backoff = policy.Backoff
+	}
+	if policy.MaxBackoff > 0 {
+		r.maxBackoff = policy.MaxBackoff
+	}
+	if r.maxBackoff < r.backoff {
+		r.maxBackoff = r.backoff
+	}
+	if policy.Retryable != nil {
+		r.retryable = policy.Retryable
+	}
+	return r
+}
+
+// IsRetryable reports whether err is a transient failure that a command may be retried after.
+// Losing the connection to elarian, network errors and rsocket errors other than application and invalid request errors are transient,
+// context cancellation and errors returned by elarian for the command are not
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, ErrUnreachable) || notSent(err) {
+		return true
+	}
+	var rsocketErr core.CustomError
+	if errors.As(err, &rsocketErr) {
+		switch rsocketErr.ErrorCode() {
+		case core.ErrorCodeApplicationError, core.ErrorCodeInvalid:
+			return false
+		default:
+			return true
+		}
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// notSent reports whether err guarantees that elarian did not process the command, which makes it safe to send again
+func notSent(err error) bool {
+	if errors.Is(err, errNotConnected) {
+		return true
+	}
+	var rsocketErr core.CustomError
+	return errors.As(err, &rsocketErr) && rsocketErr.ErrorCode() == core.ErrorCodeRejected
+}
+
+// idempotent reports whether sending a command more than once has the same effect as sending it once
+func idempotent(command string) bool {
+	switch command {
+	case "generate_auth_token",
+		"get_customer_state",
+		"add_customer_reminder",
+		"add_customer_reminder_tag",
+		"cancel_customer_reminder",
+		"cancel_customer_reminder_tag",
+		"update_customer_tag",
+		"delete_customer_tag",
+		"update_customer_secondary_id",
+		"delete_customer_secondary_id",
+		"update_customer_metadata",
+		"delete_customer_metadata",
+		"update_customer_app_data",
+		"delete_customer_app_data":
+		return true
+	default:
+		return false
+	}
+}
+
+// retry reports whether the command should be sent again after the attempt failed with err
+func (r *retrier) retry(command string, attempt int, err error) bool {
+	if attempt >= r.maxAttempts || !r.retryable(err) {
+		return false
+	}
+	return idempotent(command) || notSent(err)
+}
+
+// delay returns the delay before the given retry. It grows exponentially and half of it is randomized
+func (r *retrier) delay(retry int) time.Duration {
+	delay := r.backoff
+	for i := 1; i < retry && delay < r.maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > r.maxBackoff {
+		delay = r.maxBackoff
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return delay/2 + time.Duration(r.rand.Int63n(int64(delay/2)+1))
+}
@@ -2,10 +2,11 @@ package elarian
 
 import (
 	"context"
+	"sync"
+	"time"
 
-	"github.com/asaskevich/EventBus"
 	hera "github.com/elarianltd/go-sdk/com_elarian_hera_proto"
-	"github.com/rsocket/rsocket-go"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type (
@@ -82,14 +83,115 @@ type (
 		// NewCustomer func creates and Returns a customer instance for functionality consumable from a customer's perspective
 		NewCustomer(params *CreateCustomer) *Customer
 
-		// Disconnect closes the elarian connection
+		// Disconnect closes the elarian connection immediately, notifications that are being handled are not replied to
 		Disconnect() error
 
+		// Shutdown stops accepting notifications, waits for the ones being handled to be replied to and then closes the elarian connection.
+		// If ctx expires first the pending replies are failed, the handler contexts are cancelled, the connection is closed anyway and the context's error is returned.
+		Shutdown(ctx context.Context) error
+
+		// ConnectionState returns the current state of the connection to elarian
+		ConnectionState() ConnectionState
+
+		// OnConnectionStateChange registers a handler that is called every time the connection to elarian changes state,
+		// for example when it is lost and the sdk starts reconnecting.
+		OnConnectionStateChange(handler ConnectionStateHandler)
+
 		// InitializeNotificationStream starts listening for notifications if notifications are enabled
 		InitializeNotificationStream() <-chan error
 
-		// On registers an event to a notification handler
-		On(event Notification, handler NotificationHandler)
+		// On registers a handler for a notification. Handlers registered for the same notification are called in the order they were registered.
+		// The returned subscription can be used to remove the handler.
+		On(event Notification, handler NotificationHandler) *Subscription
+
+		// Once registers a handler that is removed after it handles its first notification
+		Once(event Notification, handler NotificationHandler) *Subscription
+
+		// Off removes a handler registered with On or Once
+		Off(subscription *Subscription)
+
+		// UseCommandInterceptors adds interceptors that every AppToServerCommand is sent through, they run in the order they were added and the first one is the outermost.
+		// Simulator commands are not intercepted.
+		UseCommandInterceptors(interceptors ...CommandInterceptor)
+
+		// Use adds middlewares that every notification handler is called through, including handlers registered before Use is called.
+		// Middlewares run in the order they were added, the first one is the outermost.
+		Use(middlewares ...Middleware)
+
+		// OnReminder registers a handler that is called when a reminder set on a customer is due
+		OnReminder(handler ReminderHandler) *Subscription
+
+		// OnMessageStatus registers a handler that is called when the delivery status of a message sent to a customer changes
+		OnMessageStatus(handler MessageStatusHandler) *Subscription
+
+		// OnMessagingSessionStarted registers a handler that is called when a messaging session with a customer starts
+		OnMessagingSessionStarted(handler MessagingSessionStartedHandler) *Subscription
+
+		// OnMessagingSessionRenewed registers a handler that is called when a messaging session with a customer is renewed
+		OnMessagingSessionRenewed(handler MessagingSessionRenewedHandler) *Subscription
+
+		// OnMessagingSessionEnded registers a handler that is called when a messaging session with a customer ends
+		OnMessagingSessionEnded(handler MessagingSessionEndedHandler) *Subscription
+
+		// OnMessagingConsentUpdate registers a handler that is called when a customer's messaging consent changes
+		OnMessagingConsentUpdate(handler MessagingConsentUpdateHandler) *Subscription
+
+		// OnReceivedEmail registers a handler that is called when an email is received from a customer
+		OnReceivedEmail(handler ReceivedEmailHandler) *Subscription
+
+		// OnReceivedUssdSession registers a handler that is called when a customer sends ussd input
+		OnReceivedUssdSession(handler ReceivedUssdSessionHandler) *Subscription
+
+		// OnReceivedVoiceCall registers a handler that is called when a voice call from a customer is received or progresses
+		OnReceivedVoiceCall(handler ReceivedVoiceCallHandler) *Subscription
+
+		// OnReceivedSms registers a handler that is called when an sms is received from a customer
+		OnReceivedSms(handler ReceivedSmsHandler) *Subscription
+
+		// OnReceivedFbMessenger registers a handler that is called when a facebook messenger message is received from a customer
+		OnReceivedFbMessenger(handler ReceivedFbMessengerHandler) *Subscription
+
+		// OnReceivedTelegram registers a handler that is called when a telegram message is received from a customer
+		OnReceivedTelegram(handler ReceivedTelegramHandler) *Subscription
+
+		// OnReceivedWhatsapp registers a handler that is called when a whatsapp message is received from a customer
+		OnReceivedWhatsapp(handler ReceivedWhatsappHandler) *Subscription
+
+		// OnReceivedMessage registers a handler that is called when a message is received on a channel that has no handlers registered for its own notification
+		OnReceivedMessage(handler ReceivedMessageHandler) *Subscription
+
+		// OnSentMessageReaction registers a handler that is called when a customer reacts to a message they were sent
+		OnSentMessageReaction(handler SentMessageReactionHandler) *Subscription
+
+		// OnReceivedPayment registers a handler that is called when a payment is received from a customer
+		OnReceivedPayment(handler ReceivedPaymentHandler) *Subscription
+
+		// OnPaymentStatus registers a handler that is called when the status of a payment changes
+		OnPaymentStatus(handler PaymentStatusHandler) *Subscription
+
+		// OnWalletPaymentStatus registers a handler that is called when the status of a wallet payment changes
+		OnWalletPaymentStatus(handler WalletPaymentStatusHandler) *Subscription
+
+		// OnCustomerActivity registers a handler that is called when a customer activity is recorded
+		OnCustomerActivity(handler CustomerActivityHandler) *Subscription
+
+		// OnPaymentPurse registers a handler that is called when the status of a purse payment changes
+		OnPaymentPurse(handler PaymentPurseHandler) *Subscription
+
+		// OnSendChannelPaymentSimulator registers a handler that is called when the simulator is asked to send a channel payment
+		OnSendChannelPaymentSimulator(handler SendChannelPaymentSimulatorHandler) *Subscription
+
+		// OnCheckoutPaymentSimulator registers a handler that is called when the simulator is asked to checkout a payment
+		OnCheckoutPaymentSimulator(handler CheckoutPaymentSimulatorHandler) *Subscription
+
+		// OnSendCustomerPaymentSimulator registers a handler that is called when the simulator is asked to send a customer payment
+		OnSendCustomerPaymentSimulator(handler SendCustomerPaymentSimulatorHandler) *Subscription
+
+		// OnMakeVoiceCallSimulator registers a handler that is called when the simulator is asked to make a voice call
+		OnMakeVoiceCallSimulator(handler MakeVoiceCallSimulatorHandler) *Subscription
+
+		// OnSendMessageSimulator registers a handler that is called when the simulator is asked to send a message
+		OnSendMessageSimulator(handler SendMessageSimulatorHandler) *Subscription
 
 		// ReceiveMessage is a simulator method that can be used to ReceiveMessage messages from a custom simulator
 		ReceiveMessage(ctx context.Context, customerNumber string, channel *MessagingChannelNumber, sessionID string, parts []*InBoundMessageBody) (*SimulatorToServerCommandReply, error)
@@ -102,45 +204,136 @@ type (
 	}
 
 	elarian struct {
-		client                       rsocket.Client
-		bus                          EventBus.Bus
-		errorChannel                 <-chan error
-		replyChannel                 chan<- *hera.ServerToAppNotificationReply
-		notificationChannel          <-chan *hera.ServerToAppNotification
-		simulatorNotificationChannel <-chan *hera.ServerToSimulatorNotification
+		client              *connection
+		bus                 *dispatcher
+		errorChannel        <-chan error
+		reportError         func(err error)
+		logger              Logger
+		secrets             []string
+		metrics             MetricsCollector
+		tracer              trace.Tracer
+		commands            *commandExecutor
+		dedup               DedupStore
+		journal             *journal
+		replays             []*journalEntry
+		replayOnce          sync.Once
+		recorder            *recorder
+		customers           *customerCache
+		customerLookup      CustomerLookup
+		statusErrors        bool
+		pool                *notificationPool
+		defaultReplyTimeout time.Duration
+		replyTimeouts       map[Notification]time.Duration
+		defaultReply        DefaultReplyFunc
+		onReplyTimeout      ReplyTimeoutHandler
+		gate                *notificationGate
 	}
 )
 
 func (s *elarian) Disconnect() error {
+	s.gate.close()
+	s.closeFiles()
 	return s.client.Close()
 }
 
-// NewService Creates a new Elarian service
-func NewService(options *Options, connectionOptions *ConnectionOptions) (Elarian, error) {
-	errorChan := make(chan error)
-	replyChan := make(chan *hera.ServerToAppNotificationReply)
-	notificationChannel := make(chan *hera.ServerToAppNotification)
-	simulatorNotificationChannel := make(chan *hera.ServerToSimulatorNotification)
+func (s *elarian) ConnectionState() ConnectionState {
+	return s.client.State()
+}
 
-	srvc := &service{
-		host:                         "tcp.elarian.dev",
-		port:                         8082,
-		errorChannel:                 errorChan,
-		replyChannel:                 replyChan,
-		notificationChannel:          notificationChannel,
-		simulatorNotificationChannel: simulatorNotificationChannel,
+func (s *elarian) OnConnectionStateChange(handler ConnectionStateHandler) {
+	s.client.OnStateChange(handler)
+}
+
+const errorChannelSize int = 16
+
+// NewService Creates a new Elarian service.
+// Connection failures are returned as a *ConnectionError, failures after the connection is established are sent on the channel returned by InitializeNotificationStream.
+func NewService(options *Options, connectionOptions *ConnectionOptions) (Elarian, error) {
+	srvc, elarian, err := newService(options)
+	if err != nil {
+		return nil, err
 	}
+	connectionOptions = withConnectionDefaults(connectionOptions)
+	srvc.host, srvc.port = connectionOptions.Host, connectionOptions.Port
 
 	client, err := srvc.connect(options, connectionOptions)
 	if err != nil {
+		elarian.closeFiles()
 		return nil, err
 	}
-	return &elarian{
-		client:                       client,
-		bus:                          EventBus.New(),
-		errorChannel:                 errorChan,
-		replyChannel:                 replyChan,
-		notificationChannel:          notificationChannel,
-		simulatorNotificationChannel: simulatorNotificationChannel,
-	}, nil
+	elarian.client = client
+	elarian.commands.transport = client
+	return elarian, nil
+}
+
+// newService builds the service that receives notifications from elarian and the one that dispatches them, neither is connected yet
+func newService(options *Options) (*service, *elarian, error) {
+	errorChan := make(chan error, errorChannelSize)
+	pool := newNotificationPool(options)
+	gate := newNotificationGate()
+	logger := newLogger(options)
+	metrics := newMetrics(options)
+	tracer := newTracer(options)
+
+	var (
+		jrnl    *journal
+		replays []*journalEntry
+		rec     *recorder
+		err     error
+	)
+	if options.JournalPath != "" {
+		if jrnl, replays, err = openJournal(options.JournalPath); err != nil {
+			return nil, nil, err
+		}
+	}
+	if options.RecordPath != "" {
+		if rec, err = openRecorder(options.RecordPath, options.RecordFormat); err != nil {
+			if jrnl != nil {
+				jrnl.close()
+			}
+			return nil, nil, err
+		}
+	}
+
+	srvc := &service{
+		logger:       logger,
+		metrics:      metrics,
+		tracer:       tracer,
+		propagator:   options.Propagator,
+		errorChannel: errorChan,
+		pool:         pool,
+		journal:      jrnl,
+		recorder:     rec,
+		gate:         gate,
+	}
+	elarian := &elarian{
+		bus:                 newDispatcher(),
+		logger:              logger,
+		secrets:             credentials(options),
+		metrics:             metrics,
+		tracer:              tracer,
+		commands:            newCommandExecutor(logger, metrics, tracer, options.Propagator, newRetrier(options.RetryPolicy)),
+		errorChannel:        errorChan,
+		pool:                pool,
+		gate:                gate,
+		defaultReplyTimeout: options.ReplyTimeout,
+		replyTimeouts:       options.ReplyTimeouts,
+		defaultReply:        options.DefaultReply,
+		onReplyTimeout:      options.OnReplyTimeout,
+		dedup:               options.DedupStore,
+		journal:             jrnl,
+		replays:             replays,
+		recorder:            rec,
+		customerLookup:      options.CustomerLookup,
+		statusErrors:        options.StatusErrors,
+	}
+	elarian.customers = newCustomerCache(options.CustomerCacheTTL, elarian.fetchCustomerNumber)
+	elarian.reportError = srvc.reportError
+	elarian.bus.onError = elarian.handlerFailed
+	elarian.bus.onFinished = metrics.HandlerFinished
+	elarian.commands.use(options.CommandInterceptors...)
+	pool.handle = elarian.handleJob
+	pool.overflow = elarian.overflowJob
+	pool.release = elarian.releaseJob
+	return srvc, elarian, nil
 }
@@ -0,0 +1,106 @@
+package elarian
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+type (
+	// notificationGate tracks the notifications being handled so that the service can stop accepting new ones and wait for the rest on shutdown.
+	// ctx is cancelled once the connection is closed, the notification channels are never closed so that late senders cannot panic.
+	// Every notification handler context is derived from ctx.
+	notificationGate struct {
+		mu       sync.RWMutex
+		closed   bool
+		inflight sync.WaitGroup
+		replies  sync.WaitGroup
+		ctx      context.Context
+		cancel   context.CancelFunc
+	}
+)
+
+var errShuttingDown = errors.New("elarian service is shutting down")
+
+func newNotificationGate() *notificationGate {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &notificationGate{ctx: ctx, cancel: cancel}
+}
+
+// enter admits a notification that is handled in the given number of steps, it returns false once the gate is closed
+func (g *notificationGate) enter(steps int) bool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	if g.closed {
+		return false
+	}
+	g.inflight.Add(steps)
+	return true
+}
+
+// accept admits a notification elarian waits for the reply to, it is handled in two steps: its reply, marked done with replied, and its handlers
+func (g *notificationGate) accept() bool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	if g.closed {
+		return false
+	}
+	g.inflight.Add(2)
+	g.replies.Add(1)
+	return true
+}
+
+// replied marks the reply of an accepted notification as done
+func (g *notificationGate) replied() {
+	g.replies.Done()
+	g.inflight.Done()
+}
+
+// leave marks one step of an admitted notification as done
+func (g *notificationGate) leave() {
+	g.inflight.Done()
+}
+
+// close stops admitting notifications
+func (g *notificationGate) close() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.closed = true
+}
+
+// wait blocks until every admitted notification is done or the context expires
+func (g *notificationGate) wait(ctx context.Context) error {
+	drained := make(chan struct{})
+	go func() {
+		g.inflight.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// finish releases everything still waiting on the connection and cancels the handler contexts
+func (g *notificationGate) finish() {
+	g.close()
+	g.cancel()
+}
+
+func (s *elarian) Shutdown(ctx context.Context) error {
+	s.gate.close()
+	s.pool.drain(s.gate.ctx)
+	err := s.gate.wait(ctx)
+	if err != nil {
+		// the replies still pending are failed and the handlers still running are cancelled, rsocket must be done with the replies before the connection is closed
+		s.gate.finish()
+		s.gate.replies.Wait()
+	}
+	s.closeFiles()
+	if closeErr := s.client.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,230 @@
+package elarian
+
+import (
+	"errors"
+
+	hera "github.com/elarianltd/go-sdk/com_elarian_hera_proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// ErrCommandFailed is matched by every CommandError, it is the error of commands whose status is a bool
+var ErrCommandFailed = errors.New("command failed")
+
+// Message delivery errors, see MessageDeliveryStatus.Err
+var (
+	ErrMessageDeliveryFailed                   = errors.New("message delivery failed")
+	ErrMessageDeliveryNoConsent                = errors.New("customer has not consented to messages on the channel")
+	ErrMessageDeliveryNoCapability             = errors.New("channel cannot deliver the message")
+	ErrMessageDeliveryExpired                  = errors.New("message expired")
+	ErrMessageDeliveryNoSessionInProgress      = errors.New("no messaging session in progress")
+	ErrMessageDeliveryOtherSessionInProgress   = errors.New("another messaging session is in progress")
+	ErrMessageDeliveryInvalidReplyToken        = errors.New("invalid reply token")
+	ErrMessageDeliveryInvalidChannelNumber     = errors.New("invalid messaging channel number")
+	ErrMessageDeliveryNotSupported             = errors.New("message not supported")
+	ErrMessageDeliveryInvalidReplyToMessageID  = errors.New("invalid reply to message id")
+	ErrMessageDeliveryInvalidCustomerID        = errors.New("invalid customer id")
+	ErrMessageDeliveryDuplicateRequest         = errors.New("duplicate message request")
+	ErrMessageDeliveryTagNotFound              = errors.New("tag not found")
+	ErrMessageDeliveryCustomerNumberNotFound   = errors.New("customer number not found")
+	ErrMessageDeliveryDecommissionedCustomerID = errors.New("customer id is decommissioned")
+	ErrMessageDeliveryRejected                 = errors.New("message rejected")
+	ErrMessageDeliveryInvalidRequest           = errors.New("invalid message request")
+	ErrMessageDeliveryApplicationError         = errors.New("message delivery application error")
+)
+
+// Messaging consent errors, see MessagingConsentUpdateStatus.Err
+var (
+	ErrMessagingConsentInvalidChannelNumber     = errors.New("invalid messaging consent channel number")
+	ErrMessagingConsentDecommissionedCustomerID = errors.New("messaging consent customer id is decommissioned")
+	ErrMessagingConsentApplicationError         = errors.New("messaging consent application error")
+)
+
+// Payment errors, see PaymentStatus.Err
+var (
+	ErrPaymentInvalidRequest           = errors.New("invalid payment request")
+	ErrPaymentNotSupported             = errors.New("payment not supported")
+	ErrPaymentInsufficientFunds        = errors.New("insufficient funds")
+	ErrPaymentApplicationError         = errors.New("payment application error")
+	ErrPaymentNotAllowed               = errors.New("payment not allowed")
+	ErrPaymentDuplicateRequest         = errors.New("duplicate payment request")
+	ErrPaymentInvalidPurse             = errors.New("invalid purse")
+	ErrPaymentInvalidWallet            = errors.New("invalid wallet")
+	ErrPaymentDecommissionedCustomerID = errors.New("payment customer id is decommissioned")
+	ErrPaymentFailed                   = errors.New("payment failed")
+	ErrPaymentThrottled                = errors.New("payment throttled")
+	ErrPaymentExpired                  = errors.New("payment expired")
+	ErrPaymentRejected                 = errors.New("payment rejected")
+	ErrPaymentReversed                 = errors.New("payment reversed")
+)
+
+// Err returns the error for a failed delivery status, or nil when the message is queued, sent or delivered
+func (s MessageDeliveryStatus) Err() error {
+	switch s {
+	case MessageDeliveryStatusFailed:
+		return ErrMessageDeliveryFailed
+	case MessageDeliveryStatusNoConsent:
+		return ErrMessageDeliveryNoConsent
+	case MessageDeliveryStatusNoCapability:
+		return ErrMessageDeliveryNoCapability
+	case MessageDeliveryStatusExpired:
+		return ErrMessageDeliveryExpired
+	case MessageDeliveryStatusNoSessionInProgress:
+		return ErrMessageDeliveryNoSessionInProgress
+	case MessageDeliveryStatusOtherSessionInProgress:
+		return ErrMessageDeliveryOtherSessionInProgress
+	case MessageDeliveryStatusInvalidReplyToken:
+		return ErrMessageDeliveryInvalidReplyToken
+	case MessageDeliveryStatusInvalidChannelNumber:
+		return ErrMessageDeliveryInvalidChannelNumber
+	case MessageDeliveryStatusNotSupported:
+		return ErrMessageDeliveryNotSupported
+	case MessageDeliveryStatusInvalidReplyToMessageID:
+		return ErrMessageDeliveryInvalidReplyToMessageID
+	case MessageDeliveryStatusInvalidCustomerID:
+		return ErrMessageDeliveryInvalidCustomerID
+	case MessageDeliveryStatusDuplicateRequest:
+		return ErrMessageDeliveryDuplicateRequest
+	case MessageDeliveryStatusTagNotFound:
+		return ErrMessageDeliveryTagNotFound
+	case MessageDeliveryStatusCustomerNumberNotFound:
+		return ErrMessageDeliveryCustomerNumberNotFound
+	case MessageDeliveryStatusDecommissionedCustomerid:
+		return ErrMessageDeliveryDecommissionedCustomerID
+	case MessageDeliveryStatusRejected:
+		return ErrMessageDeliveryRejected
+	case MessageDeliveryStatusInvalidRequest:
+		return ErrMessageDeliveryInvalidRequest
+	case MessageDeliveryStatusApplicationError:
+		return ErrMessageDeliveryApplicationError
+	}
+	if s >= MessageDeliveryStatusFailed {
+		return ErrMessageDeliveryFailed
+	}
+	return nil
+}
+
+// Err returns the error for a failed consent update status, or nil when the update is queued or completed
+func (s MessagingConsentUpdateStatus) Err() error {
+	switch s {
+	case MessagingConsentStatusInvalidChannelNumber:
+		return ErrMessagingConsentInvalidChannelNumber
+	case MessagingConsentStatusDecommissionedCustomerID:
+		return ErrMessagingConsentDecommissionedCustomerID
+	case MessagingConsentStatusApplicationError:
+		return ErrMessagingConsentApplicationError
+	}
+	if s >= MessagingConsentStatusInvalidChannelNumber {
+		return ErrMessagingConsentApplicationError
+	}
+	return nil
+}
+
+// Err returns the error for a failed payment status, or nil when the payment is pending or succeeded
+func (s PaymentStatus) Err() error {
+	switch s {
+	case PaymentStatusInvalidRequest:
+		return ErrPaymentInvalidRequest
+	case PaymentStatusNotSupported:
+		return ErrPaymentNotSupported
+	case PaymentStatusInsufficientFunds:
+		return ErrPaymentInsufficientFunds
+	case PaymentStatusApplicationError:
+		return ErrPaymentApplicationError
+	case PaymentStatusNotAllowed:
+		return ErrPaymentNotAllowed
+	case PaymentStatusDuplicateRequest:
+		return ErrPaymentDuplicateRequest
+	case PaymentStatusInvalidPurse:
+		return ErrPaymentInvalidPurse
+	case PaymentStatusInvalidWallet:
+		return ErrPaymentInvalidWallet
+	case PaymentStatusDecommissionedCustomerID:
+		return ErrPaymentDecommissionedCustomerID
+	case PaymentStatusFailed:
+		return ErrPaymentFailed
+	case PaymentStatusThrottled:
+		return ErrPaymentThrottled
+	case PaymentStatusExpired:
+		return ErrPaymentExpired
+	case PaymentStatusRejected:
+		return ErrPaymentRejected
+	case PaymentStatusReversed:
+		return ErrPaymentReversed
+	}
+	if (s >= PaymentStatusInvalidRequest && s < PaymentStatusSuccess) || s >= PaymentStatusFailed {
+		return ErrPaymentFailed
+	}
+	return nil
+}
+
+// statusError returns a CommandError when the reply to a command has a failed status, or nil when the command succeeded
+func statusError(command string, reply protoreflect.Message) error {
+	reply = entryMessage(reply)
+	status := reply.Descriptor().Fields().ByName("status")
+	if status == nil {
+		return nil
+	}
+	commandErr := &CommandError{Command: command}
+	value := reply.Get(status)
+	switch status.Kind() {
+	case protoreflect.BoolKind:
+		if value.Bool() {
+			return nil
+		}
+		commandErr.Err = ErrCommandFailed
+	case protoreflect.EnumKind:
+		commandErr.Code = int32(value.Enum())
+		switch status.Enum().FullName() {
+		case hera.MessageDeliveryStatus(0).Descriptor().FullName():
+			commandErr.Err = MessageDeliveryStatus(commandErr.Code).Err()
+		case hera.MessagingConsentUpdateStatus(0).Descriptor().FullName():
+			commandErr.Err = MessagingConsentUpdateStatus(commandErr.Code).Err()
+		case hera.PaymentStatus(0).Descriptor().FullName():
+			commandErr.Err = PaymentStatus(commandErr.Code).Err()
+		}
+		if commandErr.Err == nil {
+			return nil
+		}
+	default:
+		return nil
+	}
+	commandErr.Description = stringField(reply, "description")
+	commandErr.CustomerID = stringField(reply, "customer_id")
+	if commandErr.CustomerID == "" {
+		commandErr.CustomerID = stringField(reply, "debit_customer_id")
+	}
+	if data := reply.Descriptor().Fields().ByName("data"); commandErr.CustomerID == "" && data != nil && data.Message() != nil {
+		commandErr.CustomerID = stringField(reply.Get(data).Message(), "customer_id")
+	}
+	return commandErr
+}
+
+// entryMessage follows the entry oneofs of a message to the innermost message set, it is the message itself when it has no entry
+func entryMessage(message protoreflect.Message) protoreflect.Message {
+	for {
+		oneof := message.Descriptor().Oneofs().ByName("entry")
+		if oneof == nil {
+			return message
+		}
+		field := message.WhichOneof(oneof)
+		if field == nil || field.Message() == nil {
+			return message
+		}
+		message = message.Get(field).Message()
+	}
+}
+
+// stringField returns the value of a string or string wrapper field, it is empty when the message has no such field
+func stringField(message protoreflect.Message, name protoreflect.Name) string {
+	field := message.Descriptor().Fields().ByName(name)
+	switch {
+	case field == nil:
+		return ""
+	case field.Kind() == protoreflect.StringKind:
+		return message.Get(field).String()
+	case field.Message() != nil && field.Message().FullName() == "google.protobuf.StringValue":
+		return message.Get(field).Message().Get(field.Message().Fields().ByName("value")).String()
+	default:
+		return ""
+	}
+}
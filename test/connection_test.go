@@ -0,0 +1,329 @@
+package test
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	elarian "github.com/elarianltd/go-sdk"
+	hera "github.com/elarianltd/go-sdk/com_elarian_hera_proto"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func Test_ConnectionOptions(t *testing.T) {
+	transports := map[string]elarian.Transport{
+		"tcp":       elarian.TransportTCP,
+		"websocket": elarian.TransportWebSocket,
+	}
+	servers := map[string]func(t *testing.T, transport elarian.Transport) *standInServer{
+		"plaintext": newStandInServer,
+		"tls":       newTLSStandInServer,
+	}
+	for name, transport := range transports {
+		for security, newServer := range servers {
+			transport, newServer := transport, newServer
+			t.Run(fmt.Sprintf("It should connect over %s %s", security, name), func(t *testing.T) {
+				server := newServer(t, transport)
+				server.CommandHandler = func(command *hera.AppToServerCommand) *hera.AppToServerCommandReply {
+					return &hera.AppToServerCommandReply{
+						Entry: &hera.AppToServerCommandReply_GenerateAuthToken{
+							GenerateAuthToken: &hera.GenerateAuthTokenReply{
+								Token:    "stand-in-token",
+								Lifetime: durationpb.New(time.Minute),
+							},
+						},
+					}
+				}
+
+				service, err := elarian.Connect(server.Options())
+				if err != nil {
+					t.Fatalf("Error %v", err)
+				}
+				defer service.Disconnect()
+				server.WaitForClient(t)
+
+				setups := server.Setups()
+				assert.Len(t, setups, 1)
+				assert.Equal(t, "test_org", setups[0].OrgId)
+				assert.Equal(t, "test_app", setups[0].AppId)
+				assert.Equal(t, "test_api_key", setups[0].ApiKey.GetValue())
+
+				ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+				defer cancel()
+				response, err := service.GenerateAuthToken(ctx)
+				if err != nil {
+					t.Fatalf("Error %v", err)
+				}
+				assert.Equal(t, "stand-in-token", response.Token)
+				assert.Equal(t, time.Minute, response.LifeTime)
+			})
+		}
+	}
+
+	t.Run("It should not trust tls servers signed by other certificate authorities", func(t *testing.T) {
+		server := newTLSStandInServer(t, elarian.TransportTCP)
+		opts, conOpts := server.Options()
+		conOpts.RootCAs = x509.NewCertPool()
+		_, err := elarian.Connect(opts, conOpts)
+		assert.True(t, errors.Is(err, elarian.ErrUnreachable), "unexpected error %v", err)
+	})
+}
+
+func Test_ConnectionErrors(t *testing.T) {
+	t.Run("It should return ErrUnreachable when elarian cannot be reached", func(t *testing.T) {
+		_, err := elarian.Connect(
+			&elarian.Options{OrgID: "test_org", AppID: "test_app", APIKey: "test_api_key"},
+			&elarian.ConnectionOptions{Host: "127.0.0.1", Port: freePort(t), Insecure: true},
+		)
+		assert.Error(t, err)
+		assert.True(t, errors.Is(err, elarian.ErrUnreachable))
+
+		var connErr *elarian.ConnectionError
+		assert.True(t, errors.As(err, &connErr))
+	})
+
+	t.Run("It should surface rejected credentials on the error channel", func(t *testing.T) {
+		server := newStandInServer(t, elarian.TransportTCP)
+		server.SetupError = errors.New("invalid api key")
+
+		service, err := elarian.Connect(server.Options())
+		if err != nil {
+			t.Fatalf("Error %v", err)
+		}
+		defer service.Disconnect()
+
+		select {
+		case err := <-service.InitializeNotificationStream():
+			assert.True(t, errors.Is(err, elarian.ErrAuthFailed), "unexpected error %v", err)
+		case <-time.After(time.Second * 5):
+			t.Fatal("connection error was not reported")
+		}
+	})
+
+	rejections := map[string]error{
+		"invalid api key": elarian.ErrAuthFailed,
+		"unknown app":     elarian.ErrSetupRejected,
+	}
+	for reason, kind := range rejections {
+		reason, kind := reason, kind
+		t.Run("It should close instead of reconnecting when the setup is rejected: "+reason, func(t *testing.T) {
+			server := newStandInServer(t, elarian.TransportTCP)
+			server.SetupError = errors.New(reason)
+			metrics := elarian.NewPrometheusMetrics("")
+			opts, conOpts := server.Options()
+			opts.Metrics = metrics
+			conOpts.ReconnectBackoff = time.Millisecond * 10
+
+			service, err := elarian.Connect(opts, conOpts)
+			if err != nil {
+				t.Fatalf("Error %v", err)
+			}
+			defer service.Disconnect()
+
+			select {
+			case err := <-service.InitializeNotificationStream():
+				assert.True(t, errors.Is(err, kind), "unexpected error %v", err)
+			case <-time.After(time.Second * 5):
+				t.Fatal("connection error was not reported")
+			}
+			assert.Eventually(t, func() bool {
+				return service.ConnectionState() == elarian.ConnectionStateClosed
+			}, time.Second*5, time.Millisecond*10)
+
+			// give the supervisor time for several reconnect attempts
+			time.Sleep(time.Millisecond * 200)
+			var body strings.Builder
+			_, err = metrics.WriteTo(&body)
+			assert.NoError(t, err)
+			assert.Contains(t, body.String(), "elarian_reconnects_total 0\n")
+			assert.Equal(t, elarian.ConnectionStateClosed, service.ConnectionState())
+		})
+	}
+
+	t.Run("It should fail commands with ErrUnreachable once disconnected", func(t *testing.T) {
+		server := newStandInServer(t, elarian.TransportTCP)
+		service, err := elarian.Connect(server.Options())
+		if err != nil {
+			t.Fatalf("Error %v", err)
+		}
+		server.WaitForClient(t)
+		service.Disconnect()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+		_, err = service.GetCustomerState(ctx, elarian.CustomerID("el_cst_1"))
+		assert.True(t, errors.Is(err, elarian.ErrUnreachable), "unexpected error %v", err)
+		_, err = service.UpdatePaymentStatus(ctx, "transaction_1", elarian.PaymentStatusSuccess)
+		assert.True(t, errors.Is(err, elarian.ErrUnreachable), "unexpected error %v", err)
+		assert.Empty(t, server.Commands())
+	})
+}
+
+func Test_Reconnect(t *testing.T) {
+	t.Run("It should reconnect when the connection is lost", func(t *testing.T) {
+		server := newStandInServer(t, elarian.TransportTCP)
+		server.CommandHandler = func(command *hera.AppToServerCommand) *hera.AppToServerCommandReply {
+			return &hera.AppToServerCommandReply{
+				Entry: &hera.AppToServerCommandReply_GenerateAuthToken{
+					GenerateAuthToken: &hera.GenerateAuthTokenReply{Token: "stand-in-token", Lifetime: durationpb.New(time.Minute)},
+				},
+			}
+		}
+		opts, conOpts := server.Options()
+		conOpts.ReconnectBackoff = time.Millisecond * 10
+
+		service, err := elarian.Connect(opts, conOpts)
+		if err != nil {
+			t.Fatalf("Error %v", err)
+		}
+		defer service.Disconnect()
+		server.WaitForClient(t)
+		assert.Equal(t, elarian.ConnectionStateReady, service.ConnectionState())
+
+		states := make(chan elarian.ConnectionState, 8)
+		service.OnConnectionStateChange(func(state elarian.ConnectionState) {
+			states <- state
+		})
+		server.DropClients()
+		server.WaitForClient(t)
+
+		for _, expected := range []elarian.ConnectionState{elarian.ConnectionStateReconnecting, elarian.ConnectionStateReady} {
+			select {
+			case state := <-states:
+				assert.Equal(t, expected, state)
+			case <-time.After(time.Second * 5):
+				t.Fatalf("connection did not become %v", expected)
+			}
+		}
+		assert.Len(t, server.Setups(), 2)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+		_, err = service.GenerateAuthToken(ctx)
+		assert.NoError(t, err)
+	})
+
+	t.Run("It should close when reconnecting is disabled", func(t *testing.T) {
+		server := newStandInServer(t, elarian.TransportTCP)
+		opts, conOpts := server.Options()
+		conOpts.DisableReconnect = true
+
+		service, err := elarian.Connect(opts, conOpts)
+		if err != nil {
+			t.Fatalf("Error %v", err)
+		}
+		defer service.Disconnect()
+		server.WaitForClient(t)
+
+		closed := make(chan struct{})
+		service.OnConnectionStateChange(func(state elarian.ConnectionState) {
+			if state == elarian.ConnectionStateClosed {
+				close(closed)
+			}
+		})
+		server.DropClients()
+
+		select {
+		case <-closed:
+		case <-time.After(time.Second * 5):
+			t.Fatal("connection was not closed")
+		}
+		assert.Equal(t, elarian.ConnectionStateClosed, service.ConnectionState())
+	})
+
+	t.Run("It should report resuming the session when the transport is lost", func(t *testing.T) {
+		server := newResumableStandInServer(t, elarian.TransportTCP)
+		server.CommandHandler = func(command *hera.AppToServerCommand) *hera.AppToServerCommandReply {
+			return &hera.AppToServerCommandReply{
+				Entry: &hera.AppToServerCommandReply_GenerateAuthToken{
+					GenerateAuthToken: &hera.GenerateAuthTokenReply{Token: "stand-in-token", Lifetime: durationpb.New(time.Minute)},
+				},
+			}
+		}
+		proxy := newStandInProxy(t, server)
+		metrics := elarian.NewPrometheusMetrics("")
+		opts, conOpts := server.Options()
+		opts.Metrics = metrics
+		conOpts.Port = proxy.Port()
+		conOpts.Resumable = true
+		conOpts.ReconnectBackoff = time.Millisecond * 10
+
+		service, err := elarian.Connect(opts, conOpts)
+		if err != nil {
+			t.Fatalf("Error %v", err)
+		}
+		defer service.Disconnect()
+		server.WaitForClient(t)
+
+		states := make(chan elarian.ConnectionState, 8)
+		service.OnConnectionStateChange(func(state elarian.ConnectionState) {
+			states <- state
+		})
+		proxy.Cut()
+
+		for _, expected := range []elarian.ConnectionState{elarian.ConnectionStateReconnecting, elarian.ConnectionStateReady} {
+			select {
+			case state := <-states:
+				assert.Equal(t, expected, state)
+			case <-time.After(time.Second * 5):
+				t.Fatalf("connection did not become %v", expected)
+			}
+		}
+		// the session was resumed rather than set up again
+		assert.Len(t, server.Setups(), 1)
+		var body strings.Builder
+		_, err = metrics.WriteTo(&body)
+		assert.NoError(t, err)
+		assert.Contains(t, body.String(), "elarian_reconnects_total 1\n")
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+		_, err = service.GenerateAuthToken(ctx)
+		assert.NoError(t, err)
+	})
+
+	resumeLimits := map[string]func(conOpts *elarian.ConnectionOptions){
+		"reconnecting is disabled":       func(conOpts *elarian.ConnectionOptions) { conOpts.DisableReconnect = true },
+		"the reconnect attempts run out": func(conOpts *elarian.ConnectionOptions) { conOpts.MaxReconnectAttempts = 1 },
+	}
+	for name, limit := range resumeLimits {
+		limit := limit
+		t.Run("It should close a resumable connection when "+name, func(t *testing.T) {
+			server := newResumableStandInServer(t, elarian.TransportTCP)
+			proxy := newStandInProxy(t, server)
+			opts, conOpts := server.Options()
+			conOpts.Port = proxy.Port()
+			conOpts.Resumable = true
+			conOpts.ReconnectBackoff = time.Millisecond * 10
+			limit(conOpts)
+
+			service, err := elarian.Connect(opts, conOpts)
+			if err != nil {
+				t.Fatalf("Error %v", err)
+			}
+			defer service.Disconnect()
+			server.WaitForClient(t)
+
+			closed := make(chan struct{})
+			service.OnConnectionStateChange(func(state elarian.ConnectionState) {
+				if state == elarian.ConnectionStateClosed {
+					close(closed)
+				}
+			})
+			// attempts to resume the session fail while the proxy is down
+			proxy.Close()
+
+			select {
+			case <-closed:
+			case <-time.After(time.Second * 5):
+				t.Fatal("connection was not closed")
+			}
+			assert.Equal(t, elarian.ConnectionStateClosed, service.ConnectionState())
+		})
+	}
+}
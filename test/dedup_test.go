@@ -0,0 +1,200 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	elarian "github.com/elarianltd/go-sdk"
+	hera "github.com/elarianltd/go-sdk/com_elarian_hera_proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func receivedPaymentNotification(customerID, transactionID string) *hera.ServerToAppNotification {
+	return &hera.ServerToAppNotification{
+		Entry: &hera.ServerToAppNotification_Customer{
+			Customer: &hera.ServerToAppCustomerNotification{
+				OrgId:      "test_org",
+				AppId:      "test_app",
+				CustomerId: customerID,
+				Entry: &hera.ServerToAppCustomerNotification_ReceivedPayment{
+					ReceivedPayment: &hera.ReceivedPaymentNotification{
+						TransactionId: transactionID,
+						ChannelNumber: &hera.PaymentChannelNumber{Channel: hera.PaymentChannel_PAYMENT_CHANNEL_CELLULAR, Number: "525900"},
+						Status:        hera.PaymentStatus_PAYMENT_STATUS_SUCCESS,
+						Value:         &hera.Cash{CurrencyCode: "KES", Amount: 100},
+					},
+				},
+			},
+		},
+	}
+}
+
+func Test_DedupStores(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("It should forget the least recently added keys", func(t *testing.T) {
+		store := elarian.NewLRUDedupStore(2)
+		for _, key := range []string{"a", "b", "c"} {
+			added, err := store.Add(ctx, key)
+			assert.NoError(t, err)
+			assert.True(t, added)
+		}
+		added, _ := store.Add(ctx, "c")
+		assert.False(t, added)
+		added, _ = store.Add(ctx, "a")
+		assert.True(t, added)
+	})
+
+	t.Run("It should remember keys across restarts", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "dedup")
+		if err != nil {
+			t.Fatalf("Error %v", err)
+		}
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "notifications")
+
+		store, err := elarian.NewFileDedupStore(path, 4)
+		if err != nil {
+			t.Fatalf("Error %v", err)
+		}
+		// enough keys for the file to be compacted
+		for i := 0; i < 10; i++ {
+			added, err := store.Add(ctx, fmt.Sprintf("key_%d", i))
+			assert.NoError(t, err)
+			assert.True(t, added)
+		}
+		assert.NoError(t, store.Close())
+
+		store, err = elarian.NewFileDedupStore(path, 4)
+		if err != nil {
+			t.Fatalf("Error %v", err)
+		}
+		defer store.Close()
+		added, err := store.Add(ctx, "key_9")
+		assert.NoError(t, err)
+		assert.False(t, added)
+		added, _ = store.Add(ctx, "key_0")
+		assert.True(t, added)
+	})
+
+	t.Run("It should forget removed keys across restarts", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "dedup")
+		if err != nil {
+			t.Fatalf("Error %v", err)
+		}
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "notifications")
+
+		store, err := elarian.NewFileDedupStore(path, 4)
+		if err != nil {
+			t.Fatalf("Error %v", err)
+		}
+		for _, key := range []string{"key_1", "key_2"} {
+			_, err := store.Add(ctx, key)
+			assert.NoError(t, err)
+		}
+		assert.NoError(t, store.Remove(ctx, "key_1"))
+		assert.NoError(t, store.Remove(ctx, "key_unknown"))
+		assert.NoError(t, store.Close())
+
+		store, err = elarian.NewFileDedupStore(path, 4)
+		if err != nil {
+			t.Fatalf("Error %v", err)
+		}
+		defer store.Close()
+		added, _ := store.Add(ctx, "key_1")
+		assert.True(t, added)
+		added, _ = store.Add(ctx, "key_2")
+		assert.False(t, added)
+	})
+}
+
+func Test_NotificationDedup(t *testing.T) {
+	server := newStandInServer(t, elarian.TransportTCP)
+	opts, conOpts := server.Options()
+	opts.DedupStore = elarian.NewLRUDedupStore(0)
+	opts.ReplyTimeout = time.Millisecond * 100
+	service, err := elarian.Connect(opts, conOpts)
+	if err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	defer service.Disconnect()
+	server.WaitForClient(t)
+	service.InitializeNotificationStream()
+
+	var credited, attempts int32
+	service.OnReceivedPayment(func(ctx context.Context, svc elarian.Elarian, notification *elarian.ReceivedPaymentNotification, appData *elarian.Appdata, customer *elarian.Customer, cb elarian.NotificationCallBack) error {
+		// tx_fail fails and tx_slow does not reply the first time they are delivered
+		if notification.TransactionID == "tx_fail" || notification.TransactionID == "tx_slow" {
+			if atomic.AddInt32(&attempts, 1)%2 == 1 {
+				if notification.TransactionID == "tx_fail" {
+					return errors.New("ledger unavailable")
+				}
+				return nil
+			}
+		}
+		// tx_late is credited after the default reply was sent
+		if notification.TransactionID == "tx_late" {
+			time.Sleep(time.Millisecond * 250)
+		}
+		atomic.AddInt32(&credited, 1)
+		cb(nil, &elarian.Appdata{Value: "credited"})
+		return nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	t.Run("It should handle a redelivered notification once", func(t *testing.T) {
+		reply, err := server.Notify(ctx, receivedPaymentNotification("el_cst_1", "tx_1"))
+		assert.NoError(t, err)
+		assert.Equal(t, "credited", reply.GetDataUpdate().GetData().GetStringVal())
+
+		reply, err = server.Notify(ctx, receivedPaymentNotification("el_cst_1", "tx_1"))
+		assert.NoError(t, err)
+		assert.Nil(t, reply.GetDataUpdate())
+		assert.Equal(t, int32(1), atomic.LoadInt32(&credited))
+	})
+
+	t.Run("It should handle notifications with different identities", func(t *testing.T) {
+		_, err := server.Notify(ctx, receivedPaymentNotification("el_cst_1", "tx_2"))
+		assert.NoError(t, err)
+		assert.Equal(t, int32(2), atomic.LoadInt32(&credited))
+	})
+
+	for _, transactionID := range []string{"tx_fail", "tx_slow"} {
+		transactionID := transactionID
+		t.Run("It should handle a redelivered notification that was not handled the first time: "+transactionID, func(t *testing.T) {
+			before := atomic.LoadInt32(&credited)
+			reply, err := server.Notify(ctx, receivedPaymentNotification("el_cst_1", transactionID))
+			assert.NoError(t, err)
+			assert.Nil(t, reply.GetDataUpdate())
+
+			reply, err = server.Notify(ctx, receivedPaymentNotification("el_cst_1", transactionID))
+			assert.NoError(t, err)
+			assert.Equal(t, "credited", reply.GetDataUpdate().GetData().GetStringVal())
+			assert.Equal(t, before+1, atomic.LoadInt32(&credited))
+		})
+	}
+
+	t.Run("It should not handle a redelivered notification whose handler replied after the timeout", func(t *testing.T) {
+		before := atomic.LoadInt32(&credited)
+		reply, err := server.Notify(ctx, receivedPaymentNotification("el_cst_1", "tx_late"))
+		assert.NoError(t, err)
+		assert.Nil(t, reply.GetDataUpdate())
+
+		// the redelivery is queued behind the slow handler of the same customer, and the next payment behind the redelivery
+		reply, err = server.Notify(ctx, receivedPaymentNotification("el_cst_1", "tx_late"))
+		assert.NoError(t, err)
+		assert.Nil(t, reply.GetDataUpdate())
+		_, err = server.Notify(ctx, receivedPaymentNotification("el_cst_1", "tx_3"))
+		assert.NoError(t, err)
+		assert.Equal(t, before+2, atomic.LoadInt32(&credited))
+	})
+}
@@ -0,0 +1,78 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	elarian "github.com/elarianltd/go-sdk"
+	hera "github.com/elarianltd/go-sdk/com_elarian_hera_proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_CommandInterceptors(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		calls   []string
+		audited []*hera.AppToServerCommandReply
+	)
+	record := func(call string) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls = append(calls, call)
+	}
+	errBlocked := errors.New("blocked by policy")
+
+	server := newStandInServer(t, elarian.TransportTCP)
+	opts, conOpts := server.Options()
+	opts.CommandInterceptors = []elarian.CommandInterceptor{
+		func(ctx context.Context, command *hera.AppToServerCommand, invoker elarian.CommandInvoker) (*hera.AppToServerCommandReply, error) {
+			record("audit")
+			reply, err := invoker(ctx, command)
+			mu.Lock()
+			audited = append(audited, reply)
+			mu.Unlock()
+			return reply, err
+		},
+	}
+	service, err := elarian.Connect(opts, conOpts)
+	if err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	defer service.Disconnect()
+	server.WaitForClient(t)
+	service.UseCommandInterceptors(func(ctx context.Context, command *hera.AppToServerCommand, invoker elarian.CommandInvoker) (*hera.AppToServerCommandReply, error) {
+		record("policy")
+		if command.GetGetCustomerState().GetCustomerId() == "el_cst_blocked" {
+			return nil, errBlocked
+		}
+		return invoker(ctx, command)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	t.Run("It should send commands through the interceptors in the order they were added", func(t *testing.T) {
+		if _, err := service.GetCustomerState(ctx, elarian.CustomerID("el_cst_1")); err != nil {
+			t.Fatalf("Error %v", err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		assert.Equal(t, []string{"audit", "policy"}, calls)
+		assert.Len(t, audited, 1)
+		assert.NotNil(t, audited[0])
+		assert.Len(t, server.Commands(), 1)
+		assert.Equal(t, "el_cst_1", server.Commands()[0].GetGetCustomerState().GetCustomerId())
+	})
+
+	t.Run("It should not send commands an interceptor rejects", func(t *testing.T) {
+		_, err := service.GetCustomerState(ctx, elarian.CustomerID("el_cst_blocked"))
+		assert.True(t, errors.Is(err, errBlocked))
+		assert.Len(t, server.Commands(), 1)
+		mu.Lock()
+		defer mu.Unlock()
+		assert.Nil(t, audited[1])
+	})
+}
@@ -0,0 +1,38 @@
+//go:build go1.21
+// +build go1.21
+
+package test
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+
+	elarian "github.com/elarianltd/go-sdk"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_SlogLogger(t *testing.T) {
+	var out bytes.Buffer
+	server := newStandInServer(t, elarian.TransportTCP)
+	opts, conOpts := server.Options()
+	opts.Logger = elarian.NewSlogLogger(slog.New(slog.NewTextHandler(&out, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	service, err := elarian.Connect(opts, conOpts)
+	if err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	server.WaitForClient(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	if _, err := service.GetCustomerState(ctx, elarian.CustomerID("el_cst_1")); err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	service.Disconnect()
+
+	logs := out.String()
+	assert.Contains(t, logs, `level=INFO msg="connected to elarian"`)
+	assert.Contains(t, logs, `level=DEBUG msg="sending command" command=get_customer_state`)
+}
@@ -0,0 +1,159 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	elarian "github.com/elarianltd/go-sdk"
+	"github.com/stretchr/testify/assert"
+)
+
+type (
+	logEntry struct {
+		level   string
+		msg     string
+		keyvals []interface{}
+	}
+
+	// recordingLogger keeps every log it receives
+	recordingLogger struct {
+		mu      sync.Mutex
+		entries []logEntry
+	}
+
+	// waitingLogger signals warned and then blocks until proceed is closed every time it logs the warning msg
+	waitingLogger struct {
+		recordingLogger
+		msg     string
+		warned  chan struct{}
+		proceed chan struct{}
+	}
+)
+
+func (l *recordingLogger) Debug(msg string, keyvals ...interface{}) { l.add("debug", msg, keyvals) }
+func (l *recordingLogger) Info(msg string, keyvals ...interface{})  { l.add("info", msg, keyvals) }
+func (l *recordingLogger) Warn(msg string, keyvals ...interface{})  { l.add("warn", msg, keyvals) }
+func (l *recordingLogger) Error(msg string, keyvals ...interface{}) { l.add("error", msg, keyvals) }
+
+func (l *waitingLogger) Warn(msg string, keyvals ...interface{}) {
+	l.recordingLogger.Warn(msg, keyvals...)
+	if msg == l.msg {
+		l.warned <- struct{}{}
+		<-l.proceed
+	}
+}
+
+func (l *recordingLogger) add(level, msg string, keyvals []interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, logEntry{level: level, msg: msg, keyvals: keyvals})
+}
+
+// find returns the first log with msg
+func (l *recordingLogger) find(msg string) (logEntry, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, entry := range l.entries {
+		if entry.msg == msg {
+			return entry, true
+		}
+	}
+	return logEntry{}, false
+}
+
+// value returns the value logged for key
+func (e logEntry) value(key string) interface{} {
+	for i := 0; i+1 < len(e.keyvals); i += 2 {
+		if e.keyvals[i] == key {
+			return e.keyvals[i+1]
+		}
+	}
+	return nil
+}
+
+func Test_Logger(t *testing.T) {
+	t.Run("It should log the connection, commands and notifications", func(t *testing.T) {
+		logger := &recordingLogger{}
+		server := newStandInServer(t, elarian.TransportTCP)
+		opts, conOpts := server.Options()
+		opts.Logger = logger
+		service, err := elarian.Connect(opts, conOpts)
+		if err != nil {
+			t.Fatalf("Error %v", err)
+		}
+		server.WaitForClient(t)
+		service.InitializeNotificationStream()
+		service.OnReceivedUssdSession(func(ctx context.Context, svc elarian.Elarian, notification *elarian.UssdSessionNotification, appData *elarian.Appdata, customer *elarian.Customer, cb elarian.NotificationCallBack) error {
+			return fmt.Errorf("rejected api key %s", "test_api_key")
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+		if _, err := service.GetCustomerState(ctx, elarian.CustomerID("el_cst_1")); err != nil {
+			t.Fatalf("Error %v", err)
+		}
+		if _, err := server.Notify(ctx, ussdNotification("el_cst_1", "session_1", "1")); err != nil {
+			t.Fatalf("Error %v", err)
+		}
+		service.Disconnect()
+
+		connected, ok := logger.find("connected to elarian")
+		assert.True(t, ok)
+		assert.Equal(t, "info", connected.level)
+
+		sent, ok := logger.find("sending command")
+		assert.True(t, ok)
+		assert.Equal(t, "debug", sent.level)
+		assert.Equal(t, "get_customer_state", sent.value("command"))
+		_, ok = logger.find("received reply")
+		assert.True(t, ok)
+
+		dispatched, ok := logger.find("dispatching notification")
+		assert.True(t, ok)
+		assert.Equal(t, "el_cst_1", dispatched.value("customerId"))
+
+		failed, ok := logger.find("notification handler failed")
+		assert.True(t, ok)
+		assert.Equal(t, "error", failed.level)
+		assert.Equal(t, "rejected api key [REDACTED]", failed.value("error"))
+
+		_, ok = logger.find("elarian connection closed")
+		assert.True(t, ok)
+	})
+
+	t.Run("It should redact credentials from the standard logger", func(t *testing.T) {
+		var out bytes.Buffer
+		server := newStandInServer(t, elarian.TransportTCP)
+		opts, conOpts := server.Options()
+		opts.AuthToken = "test_auth_token"
+		opts.Logger = elarian.NewStdLogger(log.New(&out, "", 0), elarian.LogLevelDebug)
+		service, err := elarian.Connect(opts, conOpts)
+		if err != nil {
+			t.Fatalf("Error %v", err)
+		}
+		server.WaitForClient(t)
+		service.InitializeNotificationStream()
+		service.OnReceivedUssdSession(func(ctx context.Context, svc elarian.Elarian, notification *elarian.UssdSessionNotification, appData *elarian.Appdata, customer *elarian.Customer, cb elarian.NotificationCallBack) error {
+			return fmt.Errorf("token test_auth_token expired")
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+		if _, err := server.Notify(ctx, ussdNotification("el_cst_1", "session_1", "1")); err != nil {
+			t.Fatalf("Error %v", err)
+		}
+		service.Disconnect()
+
+		logs := out.String()
+		assert.Contains(t, logs, `level=info msg="connected to elarian"`)
+		assert.Contains(t, logs, `level=error msg="notification handler failed"`)
+		assert.Contains(t, logs, `error="token [REDACTED] expired"`)
+		assert.False(t, strings.Contains(logs, "test_auth_token"))
+	})
+}
@@ -0,0 +1,67 @@
+package test
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	elarian "github.com/elarianltd/go-sdk"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_PrometheusMetrics(t *testing.T) {
+	metrics := elarian.NewPrometheusMetrics("")
+	server := newStandInServer(t, elarian.TransportTCP)
+	opts, conOpts := server.Options()
+	opts.Metrics = metrics
+	opts.ReplyTimeout = time.Millisecond * 50
+	conOpts.ReconnectBackoff = time.Millisecond * 10
+	service, err := elarian.Connect(opts, conOpts)
+	if err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	defer service.Disconnect()
+	server.WaitForClient(t)
+	service.InitializeNotificationStream()
+
+	// the first session is replied to, the second one is left to time out
+	service.OnReceivedUssdSession(func(ctx context.Context, svc elarian.Elarian, notification *elarian.UssdSessionNotification, appData *elarian.Appdata, customer *elarian.Customer, cb elarian.NotificationCallBack) error {
+		if notification.SessionID == "session_1" {
+			cb(nil, nil)
+		}
+		return nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	if _, err := service.GetCustomerState(ctx, elarian.CustomerID("el_cst_1")); err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	for _, session := range []string{"session_1", "session_2"} {
+		if _, err := server.Notify(ctx, ussdNotification("el_cst_1", session, "1")); err != nil {
+			t.Fatalf("Error %v", err)
+		}
+	}
+	server.DropClients()
+	server.WaitForClient(t)
+
+	scrape := func() string {
+		recorder := httptest.NewRecorder()
+		metrics.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+		return recorder.Body.String()
+	}
+	// the reconnect and the reply timeout are recorded after the client sees them
+	assert.Eventually(t, func() bool {
+		body := scrape()
+		return strings.Contains(body, "elarian_reconnects_total 1\n") && strings.Contains(body, `elarian_reply_timeouts_total{notification="received_ussd_session"} 1`)
+	}, time.Second*5, time.Millisecond*10)
+
+	body := scrape()
+	assert.Contains(t, body, `elarian_commands_total{command="get_customer_state",result="success"} 1`)
+	assert.Contains(t, body, `elarian_command_duration_seconds_count{command="get_customer_state"} 1`)
+	assert.Contains(t, body, `elarian_notifications_total{notification="received_ussd_session"} 2`)
+	assert.Contains(t, body, `elarian_handler_duration_seconds_count{notification="received_ussd_session",result="success"} 2`)
+	assert.Contains(t, body, "# TYPE elarian_command_duration_seconds histogram")
+}
@@ -0,0 +1,1543 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	elarian "github.com/elarianltd/go-sdk"
+	hera "github.com/elarianltd/go-sdk/com_elarian_hera_proto"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func receivedMessageNotification(customerID, sessionID string, channel hera.MessagingChannel, parts ...*hera.InboundMessageBody) *hera.ServerToAppNotification {
+	return &hera.ServerToAppNotification{
+		Entry: &hera.ServerToAppNotification_Customer{
+			Customer: &hera.ServerToAppCustomerNotification{
+				OrgId:      "test_org",
+				AppId:      "test_app",
+				CustomerId: customerID,
+				Entry: &hera.ServerToAppCustomerNotification_ReceivedMessage{
+					ReceivedMessage: &hera.ReceivedMessageNotification{
+						MessageId: "msg_" + sessionID,
+						SessionId: wrapperspb.String(sessionID),
+						CustomerNumber: &hera.CustomerNumber{
+							Number:   "+254700000000",
+							Provider: hera.CustomerNumberProvider_CUSTOMER_NUMBER_PROVIDER_CELLULAR,
+						},
+						ChannelNumber: &hera.MessagingChannelNumber{
+							Channel: channel,
+							Number:  "21356",
+						},
+						Parts: parts,
+					},
+				},
+			},
+		},
+	}
+}
+
+func ussdNotification(customerID, sessionID, input string) *hera.ServerToAppNotification {
+	return receivedMessageNotification(customerID, sessionID, hera.MessagingChannel_MESSAGING_CHANNEL_USSD,
+		&hera.InboundMessageBody{Entry: &hera.InboundMessageBody_Ussd{Ussd: wrapperspb.String(input)}},
+	)
+}
+
+func Test_NotificationReplies(t *testing.T) {
+	t.Run("It should send every reply back to the notification that produced it", func(t *testing.T) {
+		server := newStandInServer(t, elarian.TransportTCP)
+		service, err := elarian.Connect(server.Options())
+		if err != nil {
+			t.Fatalf("Error %v", err)
+		}
+		defer service.Disconnect()
+		server.WaitForClient(t)
+		service.InitializeNotificationStream()
+
+		// replies are sent after a random delay so that handlers finish out of order
+		service.On(elarian.ElarianReceivedUssdSessionNotification, func(ctx context.Context, svc elarian.Elarian, notf elarian.IsNotification, appData *elarian.Appdata, customer *elarian.Customer, cb elarian.NotificationCallBack) error {
+			ussd, ok := notf.(*elarian.UssdSessionNotification)
+			if !ok {
+				return nil
+			}
+			go func() {
+				time.Sleep(time.Duration(rand.Intn(50)) * time.Millisecond)
+				cb(&elarian.UssdMenu{Text: customer.ID + ":" + ussd.SessionID}, nil)
+			}()
+			return nil
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		var wg sync.WaitGroup
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				customerID := fmt.Sprintf("el_cst_%d", i)
+				sessionID := fmt.Sprintf("session_%d", i)
+				reply, err := server.Notify(ctx, ussdNotification(customerID, sessionID, "1"))
+				if !assert.NoError(t, err) {
+					return
+				}
+				assert.Equal(t, customerID+":"+sessionID, reply.GetMessage().GetBody().GetUssd().GetText())
+			}(i)
+		}
+		wg.Wait()
+	})
+}
+
+func Test_NotificationReplyTimeout(t *testing.T) {
+	t.Run("It should send the default reply when a handler times out", func(t *testing.T) {
+		server := newStandInServer(t, elarian.TransportTCP)
+		opts, conOpts := server.Options()
+		opts.ReplyTimeouts = map[elarian.Notification]time.Duration{
+			elarian.ElarianReceivedUssdSessionNotification: time.Millisecond * 50,
+		}
+		opts.DefaultReply = func(notification elarian.Notification, customerID string) (elarian.IsOutBoundMessageBody, *elarian.Appdata) {
+			return &elarian.UssdMenu{Text: "Please try again", IsTerminal: true}, nil
+		}
+		timedOut := make(chan elarian.Notification, 1)
+		opts.OnReplyTimeout = func(notification elarian.Notification, customerID string, timeout time.Duration) {
+			assert.Equal(t, "el_cst_slow", customerID)
+			assert.Equal(t, time.Millisecond*50, timeout)
+			timedOut <- notification
+		}
+
+		service, err := elarian.Connect(opts, conOpts)
+		if err != nil {
+			t.Fatalf("Error %v", err)
+		}
+		defer service.Disconnect()
+		server.WaitForClient(t)
+		service.InitializeNotificationStream()
+		service.On(elarian.ElarianReceivedUssdSessionNotification, func(ctx context.Context, svc elarian.Elarian, notf elarian.IsNotification, appData *elarian.Appdata, customer *elarian.Customer, cb elarian.NotificationCallBack) error {
+			return nil
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+		reply, err := server.Notify(ctx, ussdNotification("el_cst_slow", "session_slow", "1"))
+		if err != nil {
+			t.Fatalf("Error %v", err)
+		}
+		assert.Equal(t, "Please try again", reply.GetMessage().GetBody().GetUssd().GetText())
+		assert.True(t, reply.GetMessage().GetBody().GetUssd().GetIsTerminal())
+
+		select {
+		case notification := <-timedOut:
+			assert.Equal(t, elarian.ElarianReceivedUssdSessionNotification, notification)
+		case <-time.After(time.Second):
+			t.Fatal("reply timeout was not reported")
+		}
+	})
+}
+
+func Test_Shutdown(t *testing.T) {
+	t.Run("It should wait for handlers that are still running before closing", func(t *testing.T) {
+		server := newStandInServer(t, elarian.TransportTCP)
+		service, err := elarian.Connect(server.Options())
+		if err != nil {
+			t.Fatalf("Error %v", err)
+		}
+		server.WaitForClient(t)
+		service.InitializeNotificationStream()
+
+		proceed := make(chan struct{})
+		var finished int32
+		service.On(elarian.ElarianReceivedUssdSessionNotification, func(ctx context.Context, svc elarian.Elarian, notf elarian.IsNotification, appData *elarian.Appdata, customer *elarian.Customer, cb elarian.NotificationCallBack) error {
+			cb(&elarian.UssdMenu{Text: "Goodbye"}, nil)
+			<-proceed
+			time.Sleep(time.Millisecond * 100)
+			atomic.StoreInt32(&finished, 1)
+			return nil
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+		reply, err := server.Notify(ctx, ussdNotification("el_cst_1", "session_1", "1"))
+		if err != nil {
+			t.Fatalf("Error %v", err)
+		}
+		assert.Equal(t, "Goodbye", reply.GetMessage().GetBody().GetUssd().GetText())
+
+		close(proceed)
+		assert.NoError(t, service.Shutdown(ctx))
+		assert.Equal(t, int32(1), atomic.LoadInt32(&finished))
+		assert.Equal(t, elarian.ConnectionStateClosed, service.ConnectionState())
+	})
+
+	t.Run("It should close when the context expires", func(t *testing.T) {
+		server := newStandInServer(t, elarian.TransportTCP)
+		opts, conOpts := server.Options()
+		opts.ReplyTimeout = time.Millisecond * 50
+		service, err := elarian.Connect(opts, conOpts)
+		if err != nil {
+			t.Fatalf("Error %v", err)
+		}
+		server.WaitForClient(t)
+		service.InitializeNotificationStream()
+
+		// the handler keeps running after elarian has been sent the default reply
+		release := make(chan struct{})
+		defer close(release)
+		service.On(elarian.ElarianReceivedUssdSessionNotification, func(ctx context.Context, svc elarian.Elarian, notf elarian.IsNotification, appData *elarian.Appdata, customer *elarian.Customer, cb elarian.NotificationCallBack) error {
+			<-release
+			return nil
+		})
+		notifyCtx, notifyCancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer notifyCancel()
+		_, err = server.Notify(notifyCtx, ussdNotification("el_cst_1", "session_1", "1"))
+		assert.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+		defer cancel()
+		err = service.Shutdown(ctx)
+		assert.True(t, errors.Is(err, context.DeadlineExceeded), "unexpected error %v", err)
+		assert.Equal(t, elarian.ConnectionStateClosed, service.ConnectionState())
+	})
+
+	t.Run("It should wait for replies that handlers are holding", func(t *testing.T) {
+		server := newStandInServer(t, elarian.TransportTCP)
+		opts, conOpts := server.Options()
+		opts.ReplyTimeout = time.Minute
+		service, err := elarian.Connect(opts, conOpts)
+		if err != nil {
+			t.Fatalf("Error %v", err)
+		}
+		server.WaitForClient(t)
+		service.InitializeNotificationStream()
+
+		// the handler keeps running until elarian has the reply so that the connection is not closed while the reply is on its way
+		holding, release, received := make(chan struct{}), make(chan struct{}), make(chan struct{})
+		service.On(elarian.ElarianReceivedUssdSessionNotification, func(ctx context.Context, svc elarian.Elarian, notf elarian.IsNotification, appData *elarian.Appdata, customer *elarian.Customer, cb elarian.NotificationCallBack) error {
+			close(holding)
+			<-release
+			cb(&elarian.UssdMenu{Text: "Goodbye"}, nil)
+			<-received
+			return nil
+		})
+		replies := make(chan *hera.ServerToAppNotificationReply, 1)
+		go func() {
+			notifyCtx, notifyCancel := context.WithTimeout(context.Background(), time.Second*5)
+			defer notifyCancel()
+			reply, err := server.Notify(notifyCtx, ussdNotification("el_cst_1", "session_1", "1"))
+			assert.NoError(t, err)
+			replies <- reply
+		}()
+		<-holding
+
+		shutdown := make(chan error, 1)
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+			defer cancel()
+			shutdown <- service.Shutdown(ctx)
+		}()
+		select {
+		case err := <-shutdown:
+			t.Fatalf("shutdown returned %v while a reply was pending", err)
+		case <-time.After(time.Millisecond * 100):
+		}
+
+		close(release)
+		assert.Equal(t, "Goodbye", (<-replies).GetMessage().GetBody().GetUssd().GetText())
+		close(received)
+		assert.NoError(t, <-shutdown)
+		assert.Equal(t, elarian.ConnectionStateClosed, service.ConnectionState())
+	})
+
+	t.Run("It should reply to notifications queued before the notification stream was initialized", func(t *testing.T) {
+		// the release of the queued notification is held until elarian has its reply so that the connection is not closed while the reply is on its way
+		logger := &waitingLogger{
+			msg:     "sent the default reply to a notification received before the notification stream was initialized",
+			warned:  make(chan struct{}, 1),
+			proceed: make(chan struct{}),
+		}
+		server := newStandInServer(t, elarian.TransportTCP)
+		opts, conOpts := server.Options()
+		opts.Logger, opts.QueueSize, opts.Backpressure = logger, 1, elarian.BackpressureReject
+		service, err := elarian.Connect(opts, conOpts)
+		if err != nil {
+			t.Fatalf("Error %v", err)
+		}
+		server.WaitForClient(t)
+
+		// the workers are never started, one notification stays queued and the other is rejected
+		type result struct {
+			reply *hera.ServerToAppNotificationReply
+			err   error
+		}
+		results := make(chan result, 2)
+		for _, sessionID := range []string{"session_1", "session_2"} {
+			go func(sessionID string) {
+				ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+				defer cancel()
+				reply, err := server.Notify(ctx, ussdNotification("el_cst_1", sessionID, "1"))
+				results <- result{reply, err}
+			}(sessionID)
+		}
+		assert.Error(t, (<-results).err)
+
+		shutdown := make(chan error, 1)
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+			defer cancel()
+			shutdown <- service.Shutdown(ctx)
+		}()
+		select {
+		case <-logger.warned:
+		case <-time.After(time.Second * 5):
+			t.Fatal("the queued notification was not replied to")
+		}
+		queued := <-results
+		assert.NoError(t, queued.err)
+		assert.Empty(t, queued.reply.GetMessage().GetBody().GetUssd().GetText())
+		close(logger.proceed)
+		assert.NoError(t, <-shutdown)
+		assert.Equal(t, elarian.ConnectionStateClosed, service.ConnectionState())
+	})
+}
+
+func Test_NotificationHandlers(t *testing.T) {
+	server := newStandInServer(t, elarian.TransportTCP)
+	service, err := elarian.Connect(server.Options())
+	if err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	defer service.Disconnect()
+	server.WaitForClient(t)
+	service.InitializeNotificationStream()
+
+	var mu sync.Mutex
+	calls := []string{}
+	handler := func(name string) elarian.NotificationHandler {
+		return func(ctx context.Context, svc elarian.Elarian, notf elarian.IsNotification, appData *elarian.Appdata, customer *elarian.Customer, cb elarian.NotificationCallBack) error {
+			mu.Lock()
+			calls = append(calls, name)
+			mu.Unlock()
+			cb(nil, nil)
+			return nil
+		}
+	}
+	notify := func(t *testing.T) []string {
+		mu.Lock()
+		calls = []string{}
+		mu.Unlock()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+		if _, err := server.Notify(ctx, ussdNotification("el_cst_1", "session_1", "1")); err != nil {
+			t.Fatalf("Error %v", err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string{}, calls...)
+	}
+
+	first := service.On(elarian.ElarianReceivedUssdSessionNotification, handler("first"))
+	service.Once(elarian.ElarianReceivedUssdSessionNotification, handler("once"))
+	service.On(elarian.ElarianReceivedUssdSessionNotification, handler("last"))
+	service.On(elarian.ElarianReceivedSmsNotification, handler("sms"))
+
+	t.Run("It should call every handler in the order they were registered", func(t *testing.T) {
+		assert.Equal(t, []string{"first", "once", "last"}, notify(t))
+	})
+
+	t.Run("It should remove once handlers after their first notification", func(t *testing.T) {
+		assert.Equal(t, []string{"first", "last"}, notify(t))
+	})
+
+	t.Run("It should not call handlers that were turned off", func(t *testing.T) {
+		service.Off(first)
+		first.Off()
+		assert.Equal(t, []string{"last"}, notify(t))
+	})
+}
+
+func Test_TypedNotificationHandlers(t *testing.T) {
+	server := newStandInServer(t, elarian.TransportTCP)
+	service, err := elarian.Connect(server.Options())
+	if err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	defer service.Disconnect()
+	server.WaitForClient(t)
+	errs := service.InitializeNotificationStream()
+
+	t.Run("It should call typed handlers with the concrete notification", func(t *testing.T) {
+		sub := service.OnReceivedUssdSession(func(ctx context.Context, svc elarian.Elarian, notification *elarian.UssdSessionNotification, appData *elarian.Appdata, customer *elarian.Customer, cb elarian.NotificationCallBack) error {
+			cb(&elarian.UssdMenu{Text: notification.SessionID + ":" + notification.Input}, nil)
+			return nil
+		})
+		defer sub.Off()
+		service.OnReceivedSms(func(ctx context.Context, svc elarian.Elarian, notification *elarian.InBoundMessageBody, appData *elarian.Appdata, customer *elarian.Customer, cb elarian.NotificationCallBack) error {
+			t.Error("sms handler called for a ussd notification")
+			return nil
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+		reply, err := server.Notify(ctx, ussdNotification("el_cst_1", "session_1", "2"))
+		if err != nil {
+			t.Fatalf("Error %v", err)
+		}
+		assert.Equal(t, "session_1:2", reply.GetMessage().GetBody().GetUssd().GetText())
+	})
+
+	t.Run("It should fail typed handlers that are published another notification", func(t *testing.T) {
+		// the middleware hands the ussd handler an sms body instead of the ussd session
+		service.Use(func(next elarian.NotificationHandler) elarian.NotificationHandler {
+			return func(ctx context.Context, svc elarian.Elarian, notification elarian.IsNotification, appData *elarian.Appdata, customer *elarian.Customer, cb elarian.NotificationCallBack) error {
+				return next(ctx, svc, &elarian.InBoundMessageBody{Text: "hello"}, appData, customer, cb)
+			}
+		})
+		sub := service.OnReceivedUssdSession(func(ctx context.Context, svc elarian.Elarian, notification *elarian.UssdSessionNotification, appData *elarian.Appdata, customer *elarian.Customer, cb elarian.NotificationCallBack) error {
+			t.Error("ussd handler called with another notification")
+			return nil
+		})
+		defer sub.Off()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+		if _, err := server.Notify(ctx, ussdNotification("el_cst_1", "session_2", "1")); err != nil {
+			t.Fatalf("Error %v", err)
+		}
+		var handlerErr *elarian.HandlerError
+		err := <-errs
+		assert.True(t, errors.As(err, &handlerErr), "unexpected error %v", err)
+		assert.True(t, errors.Is(err, elarian.ErrUnexpectedNotification), "unexpected error %v", err)
+		assert.Equal(t, elarian.ElarianReceivedUssdSessionNotification, handlerErr.Notification)
+	})
+}
+
+func Test_ReceivedMessageRouting(t *testing.T) {
+	server := newStandInServer(t, elarian.TransportTCP)
+	service, err := elarian.Connect(server.Options())
+	if err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	defer service.Disconnect()
+	server.WaitForClient(t)
+	service.InitializeNotificationStream()
+
+	received := make(chan elarian.Notification, 8)
+	record := func(kind elarian.Notification) elarian.NotificationHandler {
+		return func(ctx context.Context, svc elarian.Elarian, notf elarian.IsNotification, appData *elarian.Appdata, customer *elarian.Customer, cb elarian.NotificationCallBack) error {
+			received <- kind
+			cb(nil, nil)
+			return nil
+		}
+	}
+	subscriptions := map[elarian.Notification]*elarian.Subscription{}
+	for _, kind := range []elarian.Notification{
+		elarian.ElarianReceivedSmsNotification,
+		elarian.ElarianReceivedVoiceCallNotification,
+		elarian.ElarianReceivedUssdSessionNotification,
+		elarian.ElarianReceivedFbMessengerNotification,
+		elarian.ElarianReceivedTelegramNotification,
+		elarian.ElarianReceivedWhatsappNotification,
+		elarian.ElarianReceivedEmailNotification,
+		elarian.ElarianReceivedMessageNotification,
+	} {
+		subscriptions[kind] = service.On(kind, record(kind))
+	}
+
+	textPart := &hera.InboundMessageBody{Entry: &hera.InboundMessageBody_Text{Text: "hello"}}
+	parts := map[hera.MessagingChannel]*hera.InboundMessageBody{
+		hera.MessagingChannel_MESSAGING_CHANNEL_VOICE: {Entry: &hera.InboundMessageBody_Voice{Voice: &hera.VoiceCallInputMessageBody{}}},
+		hera.MessagingChannel_MESSAGING_CHANNEL_USSD:  {Entry: &hera.InboundMessageBody_Ussd{Ussd: wrapperspb.String("1")}},
+		hera.MessagingChannel_MESSAGING_CHANNEL_EMAIL: {Entry: &hera.InboundMessageBody_Email{Email: &hera.EmailMessageBody{Subject: "hello"}}},
+	}
+	expected := map[hera.MessagingChannel]elarian.Notification{
+		hera.MessagingChannel_MESSAGING_CHANNEL_UNSPECIFIED:  elarian.ElarianReceivedMessageNotification,
+		hera.MessagingChannel_MESSAGING_CHANNEL_SMS:          elarian.ElarianReceivedSmsNotification,
+		hera.MessagingChannel_MESSAGING_CHANNEL_VOICE:        elarian.ElarianReceivedVoiceCallNotification,
+		hera.MessagingChannel_MESSAGING_CHANNEL_USSD:         elarian.ElarianReceivedUssdSessionNotification,
+		hera.MessagingChannel_MESSAGING_CHANNEL_FB_MESSENGER: elarian.ElarianReceivedFbMessengerNotification,
+		hera.MessagingChannel_MESSAGING_CHANNEL_TELEGRAM:     elarian.ElarianReceivedTelegramNotification,
+		hera.MessagingChannel_MESSAGING_CHANNEL_WHATSAPP:     elarian.ElarianReceivedWhatsappNotification,
+		hera.MessagingChannel_MESSAGING_CHANNEL_EMAIL:        elarian.ElarianReceivedEmailNotification,
+	}
+	// the channel's own part is sent when no parts are given
+	notify := func(t *testing.T, channel hera.MessagingChannel, given ...*hera.InboundMessageBody) elarian.Notification {
+		if given == nil {
+			part, ok := parts[channel]
+			if !ok {
+				part = textPart
+			}
+			given = []*hera.InboundMessageBody{part}
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+		if _, err := server.Notify(ctx, receivedMessageNotification("el_cst_1", "session_1", channel, given...)); err != nil {
+			t.Fatalf("Error %v", err)
+		}
+		select {
+		case kind := <-received:
+			assert.Len(t, received, 0)
+			return kind
+		case <-time.After(time.Second):
+			t.Fatal("no handler was called")
+			return 0
+		}
+	}
+
+	for value, name := range hera.MessagingChannel_name {
+		channel := hera.MessagingChannel(value)
+		t.Run("It should route "+name, func(t *testing.T) {
+			kind, ok := expected[channel]
+			if !ok {
+				t.Fatalf("no expected notification for %v", name)
+			}
+			assert.Equal(t, kind, notify(t, channel))
+		})
+	}
+
+	// none of these parts are published to the channel's handler so the generic handler gets the message
+	unmatched := map[string]struct {
+		channel hera.MessagingChannel
+		parts   []*hera.InboundMessageBody
+	}{
+		"a text part on the ussd channel":  {hera.MessagingChannel_MESSAGING_CHANNEL_USSD, []*hera.InboundMessageBody{textPart}},
+		"a text part on the voice channel": {hera.MessagingChannel_MESSAGING_CHANNEL_VOICE, []*hera.InboundMessageBody{textPart}},
+		"a text part on the email channel": {hera.MessagingChannel_MESSAGING_CHANNEL_EMAIL, []*hera.InboundMessageBody{textPart}},
+		"no parts on the ussd channel":     {hera.MessagingChannel_MESSAGING_CHANNEL_USSD, []*hera.InboundMessageBody{}},
+		"no parts on the sms channel":      {hera.MessagingChannel_MESSAGING_CHANNEL_SMS, []*hera.InboundMessageBody{}},
+	}
+	for name, message := range unmatched {
+		message := message
+		t.Run("It should fall back for "+name, func(t *testing.T) {
+			assert.Equal(t, elarian.ElarianReceivedMessageNotification, notify(t, message.channel, message.parts...))
+		})
+	}
+
+	t.Run("It should fall back for unknown channels", func(t *testing.T) {
+		assert.Equal(t, elarian.ElarianReceivedMessageNotification, notify(t, hera.MessagingChannel(99)))
+	})
+
+	t.Run("It should fall back for channels without handlers", func(t *testing.T) {
+		subscriptions[elarian.ElarianReceivedSmsNotification].Off()
+		assert.Equal(t, elarian.ElarianReceivedMessageNotification, notify(t, hera.MessagingChannel_MESSAGING_CHANNEL_SMS))
+	})
+}
+
+func Test_NotificationContext(t *testing.T) {
+	server := newStandInServer(t, elarian.TransportTCP)
+	opts, conOpts := server.Options()
+	opts.ReplyTimeout = time.Second * 2
+	service, err := elarian.Connect(opts, conOpts)
+	if err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	defer service.Disconnect()
+	server.WaitForClient(t)
+	service.InitializeNotificationStream()
+
+	contexts := make(chan context.Context, 1)
+	service.OnReceivedUssdSession(func(ctx context.Context, svc elarian.Elarian, notification *elarian.UssdSessionNotification, appData *elarian.Appdata, customer *elarian.Customer, cb elarian.NotificationCallBack) error {
+		contexts <- ctx
+		cb(nil, nil)
+		return nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	sent := time.Now()
+	if _, err := server.Notify(ctx, ussdNotification("el_cst_1", "session_1", "1")); err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	handlerCtx := <-contexts
+
+	t.Run("It should expire with the reply timeout", func(t *testing.T) {
+		deadline, ok := handlerCtx.Deadline()
+		assert.True(t, ok)
+		assert.WithinDuration(t, sent.Add(time.Second*2), deadline, time.Second)
+	})
+
+	t.Run("It should carry the notification values", func(t *testing.T) {
+		assert.Equal(t, "test_org", elarian.OrgIDFromContext(handlerCtx))
+		assert.Equal(t, "test_app", elarian.AppIDFromContext(handlerCtx))
+		assert.Equal(t, "el_cst_1", elarian.CustomerIDFromContext(handlerCtx))
+		kind, ok := elarian.NotificationFromContext(handlerCtx)
+		assert.True(t, ok)
+		assert.Equal(t, elarian.ElarianReceivedUssdSessionNotification, kind)
+	})
+
+	t.Run("It should be cancelled when the service shuts down", func(t *testing.T) {
+		assert.NoError(t, handlerCtx.Err())
+		service.Disconnect()
+		select {
+		case <-handlerCtx.Done():
+			assert.True(t, errors.Is(handlerCtx.Err(), context.Canceled))
+		case <-time.After(time.Second):
+			t.Fatal("context was not cancelled")
+		}
+	})
+}
+
+func Test_NotificationWorkers(t *testing.T) {
+	replyText := func(svc elarian.Elarian, cb elarian.NotificationCallBack, text string) {
+		cb(&elarian.UssdMenu{Text: text}, nil)
+	}
+
+	t.Run("It should not hold up other customers behind a slow handler", func(t *testing.T) {
+		server := newStandInServer(t, elarian.TransportTCP)
+		opts, conOpts := server.Options()
+		opts.Workers = 2
+		service, err := elarian.Connect(opts, conOpts)
+		if err != nil {
+			t.Fatalf("Error %v", err)
+		}
+		defer service.Disconnect()
+		server.WaitForClient(t)
+		service.InitializeNotificationStream()
+
+		slowID := "el_cst_slow"
+		started, release := make(chan struct{}), make(chan struct{})
+		service.OnReceivedUssdSession(func(ctx context.Context, svc elarian.Elarian, notification *elarian.UssdSessionNotification, appData *elarian.Appdata, customer *elarian.Customer, cb elarian.NotificationCallBack) error {
+			if customer.ID == slowID {
+				close(started)
+				<-release
+			}
+			replyText(svc, cb, customer.ID)
+			return nil
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+		slow := make(chan error, 1)
+		go func() {
+			_, err := server.Notify(ctx, ussdNotification(slowID, "session_slow", "1"))
+			slow <- err
+		}()
+		<-started
+
+		// the other customers are spread over both workers, those on the free worker are handled while the slow handler runs
+		customers := 16
+		replies := make(chan error, customers)
+		for i := 0; i < customers; i++ {
+			customerID := fmt.Sprintf("el_cst_%d", i)
+			go func() {
+				reply, err := server.Notify(ctx, ussdNotification(customerID, "session_fast", "1"))
+				if err == nil && reply.GetMessage().GetBody().GetUssd().GetText() != customerID {
+					err = fmt.Errorf("%s got the reply %v", customerID, reply)
+				}
+				replies <- err
+			}()
+		}
+		handled := 0
+		select {
+		case err := <-replies:
+			assert.NoError(t, err)
+			handled++
+		case <-time.After(time.Second * 2):
+			t.Errorf("every customer was queued behind %s", slowID)
+		}
+		close(release)
+		for ; handled < customers; handled++ {
+			assert.NoError(t, <-replies)
+		}
+		assert.NoError(t, <-slow)
+	})
+
+	t.Run("It should handle notifications about the same customer in order", func(t *testing.T) {
+		server := newStandInServer(t, elarian.TransportTCP)
+		opts, conOpts := server.Options()
+		opts.Workers = 4
+		service, err := elarian.Connect(opts, conOpts)
+		if err != nil {
+			t.Fatalf("Error %v", err)
+		}
+		defer service.Disconnect()
+		server.WaitForClient(t)
+		service.InitializeNotificationStream()
+
+		// handlers reply straight away and keep running so that the next notification is queued behind them
+		handled := make(chan string, 20)
+		service.OnReceivedUssdSession(func(ctx context.Context, svc elarian.Elarian, notification *elarian.UssdSessionNotification, appData *elarian.Appdata, customer *elarian.Customer, cb elarian.NotificationCallBack) error {
+			cb(nil, nil)
+			time.Sleep(time.Duration(rand.Intn(10)) * time.Millisecond)
+			handled <- notification.SessionID
+			return nil
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+		for i := 0; i < cap(handled); i++ {
+			if _, err := server.Notify(ctx, ussdNotification("el_cst_1", fmt.Sprintf("session_%d", i), "1")); err != nil {
+				t.Fatalf("Error %v", err)
+			}
+		}
+		for i := 0; i < cap(handled); i++ {
+			assert.Equal(t, fmt.Sprintf("session_%d", i), <-handled)
+		}
+	})
+
+	type result struct {
+		reply *hera.ServerToAppNotificationReply
+		err   error
+	}
+
+	// backpressure keeps the only worker busy with one notification and sends two more while its queue holds one,
+	// the overflowing notification is the one that completes before the worker is released
+	backpressure := func(t *testing.T, policy elarian.BackpressurePolicy, overflowed func(res result)) {
+		server := newStandInServer(t, elarian.TransportTCP)
+		opts, conOpts := server.Options()
+		opts.QueueSize = 1
+		opts.Backpressure = policy
+		opts.DefaultReply = func(notification elarian.Notification, customerID string) (elarian.IsOutBoundMessageBody, *elarian.Appdata) {
+			return &elarian.UssdMenu{Text: "busy:" + customerID, IsTerminal: true}, nil
+		}
+		service, err := elarian.Connect(opts, conOpts)
+		if err != nil {
+			t.Fatalf("Error %v", err)
+		}
+		defer service.Disconnect()
+		server.WaitForClient(t)
+		service.InitializeNotificationStream()
+
+		started, release := make(chan struct{}, 3), make(chan struct{})
+		service.OnReceivedUssdSession(func(ctx context.Context, svc elarian.Elarian, notification *elarian.UssdSessionNotification, appData *elarian.Appdata, customer *elarian.Customer, cb elarian.NotificationCallBack) error {
+			started <- struct{}{}
+			<-release
+			replyText(svc, cb, "handled")
+			return nil
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+		results := make(chan result, 3)
+		notify := func(sessionID string) {
+			reply, err := server.Notify(ctx, ussdNotification("el_cst_1", sessionID, "1"))
+			results <- result{reply, err}
+		}
+		go notify("session_1")
+		<-started
+		go notify("session_2")
+		go notify("session_3")
+
+		overflowed(<-results)
+		close(release)
+		for i := 0; i < 2; i++ {
+			res := <-results
+			assert.NoError(t, res.err)
+			assert.Equal(t, "handled", res.reply.GetMessage().GetBody().GetUssd().GetText())
+		}
+	}
+
+	t.Run("It should send the default reply when the queue is full", func(t *testing.T) {
+		backpressure(t, elarian.BackpressureDefaultReply, func(res result) {
+			assert.NoError(t, res.err)
+			assert.Equal(t, "busy:el_cst_1", res.reply.GetMessage().GetBody().GetUssd().GetText())
+			assert.True(t, res.reply.GetMessage().GetBody().GetUssd().GetIsTerminal())
+		})
+	})
+
+	t.Run("It should reject notifications when the queue is full", func(t *testing.T) {
+		backpressure(t, elarian.BackpressureReject, func(res result) {
+			assert.Error(t, res.err)
+		})
+	})
+}
+
+func Test_HandlerErrors(t *testing.T) {
+	server := newStandInServer(t, elarian.TransportTCP)
+	opts, conOpts := server.Options()
+	opts.ReplyTimeout = time.Second * 10
+	opts.DefaultReply = func(notification elarian.Notification, customerID string) (elarian.IsOutBoundMessageBody, *elarian.Appdata) {
+		return &elarian.UssdMenu{Text: "Something went wrong", IsTerminal: true}, nil
+	}
+	service, err := elarian.Connect(opts, conOpts)
+	if err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	defer service.Disconnect()
+	server.WaitForClient(t)
+	errs := service.InitializeNotificationStream()
+
+	errFailed := errors.New("failed")
+	service.OnReceivedUssdSession(func(ctx context.Context, svc elarian.Elarian, notification *elarian.UssdSessionNotification, appData *elarian.Appdata, customer *elarian.Customer, cb elarian.NotificationCallBack) error {
+		switch notification.Input {
+		case "panic":
+			panic("handler panicked")
+		case "error":
+			return errFailed
+		}
+		cb(&elarian.UssdMenu{Text: "ok"}, nil)
+		return nil
+	})
+
+	notify := func(t *testing.T, input string) *elarian.HandlerError {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+		sent := time.Now()
+		reply, err := server.Notify(ctx, ussdNotification("el_cst_1", "session_"+input, input))
+		if err != nil {
+			t.Fatalf("Error %v", err)
+		}
+		assert.Less(t, int64(time.Since(sent)), int64(time.Second*5))
+		assert.Equal(t, "Something went wrong", reply.GetMessage().GetBody().GetUssd().GetText())
+
+		select {
+		case err := <-errs:
+			var handlerErr *elarian.HandlerError
+			if !assert.True(t, errors.As(err, &handlerErr)) {
+				return nil
+			}
+			assert.Equal(t, elarian.ElarianReceivedUssdSessionNotification, handlerErr.Notification)
+			assert.Equal(t, "el_cst_1", handlerErr.CustomerID)
+			return handlerErr
+		case <-time.After(time.Second):
+			t.Fatal("handler error was not reported")
+			return nil
+		}
+	}
+
+	t.Run("It should recover from a panic and send the default reply straight away", func(t *testing.T) {
+		handlerErr := notify(t, "panic")
+		if handlerErr == nil {
+			return
+		}
+		assert.Equal(t, "handler panicked", handlerErr.Panic)
+		assert.NotEmpty(t, handlerErr.Stack)
+	})
+
+	t.Run("It should report errors returned by handlers and send the default reply straight away", func(t *testing.T) {
+		handlerErr := notify(t, "error")
+		if handlerErr == nil {
+			return
+		}
+		assert.True(t, errors.Is(handlerErr, errFailed))
+		assert.Nil(t, handlerErr.Panic)
+		assert.Empty(t, handlerErr.Stack)
+	})
+
+	t.Run("It should keep handling notifications after a handler panics", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+		reply, err := server.Notify(ctx, ussdNotification("el_cst_1", "session_ok", "1"))
+		if err != nil {
+			t.Fatalf("Error %v", err)
+		}
+		assert.Equal(t, "ok", reply.GetMessage().GetBody().GetUssd().GetText())
+	})
+}
+
+func Test_Middleware(t *testing.T) {
+	connect := func(t *testing.T) (*standInServer, elarian.Elarian, <-chan error) {
+		server := newStandInServer(t, elarian.TransportTCP)
+		service, err := elarian.Connect(server.Options())
+		if err != nil {
+			t.Fatalf("Error %v", err)
+		}
+		server.WaitForClient(t)
+		return server, service, service.InitializeNotificationStream()
+	}
+	notify := func(t *testing.T, server *standInServer, sessionID string) *hera.ServerToAppNotificationReply {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+		reply, err := server.Notify(ctx, ussdNotification("el_cst_1", sessionID, "1"))
+		if err != nil {
+			t.Fatalf("Error %v", err)
+		}
+		return reply
+	}
+	reply := func(ctx context.Context, svc elarian.Elarian, notification *elarian.UssdSessionNotification, appData *elarian.Appdata, customer *elarian.Customer, cb elarian.NotificationCallBack) error {
+		cb(&elarian.UssdMenu{Text: notification.SessionID}, nil)
+		return nil
+	}
+
+	t.Run("It should call handlers through the middlewares in the order they were added", func(t *testing.T) {
+		server, service, _ := connect(t)
+		defer service.Disconnect()
+
+		calls := make(chan string, 5)
+		record := func(name string) elarian.Middleware {
+			return func(next elarian.NotificationHandler) elarian.NotificationHandler {
+				return func(ctx context.Context, svc elarian.Elarian, notification elarian.IsNotification, appData *elarian.Appdata, customer *elarian.Customer, cb elarian.NotificationCallBack) error {
+					calls <- name + ":before"
+					err := next(ctx, svc, notification, appData, customer, cb)
+					calls <- name + ":after"
+					return err
+				}
+			}
+		}
+		service.OnReceivedUssdSession(func(ctx context.Context, svc elarian.Elarian, notification *elarian.UssdSessionNotification, appData *elarian.Appdata, customer *elarian.Customer, cb elarian.NotificationCallBack) error {
+			calls <- "handler"
+			return reply(ctx, svc, notification, appData, customer, cb)
+		})
+		service.Use(record("first"), record("second"))
+
+		notify(t, server, "session_1")
+		for _, call := range []string{"first:before", "second:before", "handler", "second:after", "first:after"} {
+			assert.Equal(t, call, <-calls)
+		}
+	})
+
+	t.Run("It should log and time every notification", func(t *testing.T) {
+		server, service, _ := connect(t)
+		defer service.Disconnect()
+
+		logger := &recordingLogger{}
+		timings := make(chan elarian.Notification, 1)
+		service.Use(
+			elarian.TimingMiddleware(func(ctx context.Context, notification elarian.Notification, duration time.Duration, err error) {
+				assert.NoError(t, err)
+				assert.True(t, duration > 0)
+				timings <- notification
+			}),
+			elarian.LoggingMiddleware(logger),
+		)
+		service.OnReceivedUssdSession(reply)
+
+		notify(t, server, "session_1")
+		assert.Equal(t, elarian.ElarianReceivedUssdSessionNotification, <-timings)
+		entry, ok := logger.find("handled notification")
+		if assert.True(t, ok) {
+			assert.Equal(t, "info", entry.level)
+			assert.Equal(t, "received_ussd_session", entry.value("notification"))
+			assert.Equal(t, "el_cst_1", entry.value("customerId"))
+			assert.Equal(t, "test_org", entry.value("orgId"))
+		}
+	})
+
+	t.Run("It should redact credentials from the logs of failed handlers", func(t *testing.T) {
+		server, service, errs := connect(t)
+		defer service.Disconnect()
+
+		logger := &recordingLogger{}
+		service.Use(elarian.LoggingMiddleware(logger))
+		service.OnReceivedUssdSession(func(ctx context.Context, svc elarian.Elarian, notification *elarian.UssdSessionNotification, appData *elarian.Appdata, customer *elarian.Customer, cb elarian.NotificationCallBack) error {
+			return errors.New("request with api key test_api_key failed")
+		})
+
+		notify(t, server, "session_1")
+		<-errs
+		entry, ok := logger.find("handled notification")
+		if assert.True(t, ok) {
+			assert.Equal(t, "error", entry.level)
+			assert.Equal(t, "request with api key [REDACTED] failed", entry.value("error"))
+		}
+	})
+
+	t.Run("It should let earlier middlewares see recovered panics as errors", func(t *testing.T) {
+		server, service, errs := connect(t)
+		defer service.Disconnect()
+
+		observed := make(chan error, 1)
+		service.Use(
+			elarian.TimingMiddleware(func(ctx context.Context, notification elarian.Notification, duration time.Duration, err error) {
+				observed <- err
+			}),
+			elarian.RecoveryMiddleware(),
+		)
+		service.OnReceivedUssdSession(func(ctx context.Context, svc elarian.Elarian, notification *elarian.UssdSessionNotification, appData *elarian.Appdata, customer *elarian.Customer, cb elarian.NotificationCallBack) error {
+			panic("handler panicked")
+		})
+
+		notify(t, server, "session_1")
+		var handlerErr *elarian.HandlerError
+		assert.True(t, errors.As(<-observed, &handlerErr))
+		assert.Equal(t, "handler panicked", handlerErr.Panic)
+		assert.True(t, errors.As(<-errs, &handlerErr))
+	})
+
+	t.Run("It should skip duplicate notifications", func(t *testing.T) {
+		server, service, _ := connect(t)
+		defer service.Disconnect()
+
+		var handled int32
+		service.Use(elarian.DedupMiddleware(nil, func(ctx context.Context, notification elarian.IsNotification) string {
+			if ussd, ok := notification.(*elarian.UssdSessionNotification); ok {
+				return ussd.SessionID
+			}
+			return ""
+		}))
+		service.OnReceivedUssdSession(func(ctx context.Context, svc elarian.Elarian, notification *elarian.UssdSessionNotification, appData *elarian.Appdata, customer *elarian.Customer, cb elarian.NotificationCallBack) error {
+			atomic.AddInt32(&handled, 1)
+			return reply(ctx, svc, notification, appData, customer, cb)
+		})
+
+		assert.Equal(t, "session_1", notify(t, server, "session_1").GetMessage().GetBody().GetUssd().GetText())
+		assert.Empty(t, notify(t, server, "session_1").GetMessage().GetBody().GetUssd().GetText())
+		assert.Equal(t, "session_2", notify(t, server, "session_2").GetMessage().GetBody().GetUssd().GetText())
+		assert.Equal(t, int32(2), atomic.LoadInt32(&handled))
+	})
+
+	t.Run("It should call every handler of a notification that is not a duplicate", func(t *testing.T) {
+		server, service, _ := connect(t)
+		defer service.Disconnect()
+
+		var first, second int32
+		service.Use(elarian.DedupMiddleware(nil, func(ctx context.Context, notification elarian.IsNotification) string {
+			return notification.(*elarian.UssdSessionNotification).SessionID
+		}))
+		service.OnReceivedUssdSession(func(ctx context.Context, svc elarian.Elarian, notification *elarian.UssdSessionNotification, appData *elarian.Appdata, customer *elarian.Customer, cb elarian.NotificationCallBack) error {
+			atomic.AddInt32(&first, 1)
+			return nil
+		})
+		service.OnReceivedUssdSession(func(ctx context.Context, svc elarian.Elarian, notification *elarian.UssdSessionNotification, appData *elarian.Appdata, customer *elarian.Customer, cb elarian.NotificationCallBack) error {
+			atomic.AddInt32(&second, 1)
+			return reply(ctx, svc, notification, appData, customer, cb)
+		})
+
+		assert.Equal(t, "session_1", notify(t, server, "session_1").GetMessage().GetBody().GetUssd().GetText())
+		assert.Empty(t, notify(t, server, "session_1").GetMessage().GetBody().GetUssd().GetText())
+		assert.Equal(t, int32(1), atomic.LoadInt32(&first))
+		assert.Equal(t, int32(1), atomic.LoadInt32(&second))
+	})
+
+	t.Run("It should handle notifications again after their handler failed", func(t *testing.T) {
+		server, service, errs := connect(t)
+		defer service.Disconnect()
+
+		var handled int32
+		service.Use(elarian.DedupMiddleware(elarian.NewLRUDedupStore(10), func(ctx context.Context, notification elarian.IsNotification) string {
+			return notification.(*elarian.UssdSessionNotification).SessionID
+		}))
+		service.OnReceivedUssdSession(func(ctx context.Context, svc elarian.Elarian, notification *elarian.UssdSessionNotification, appData *elarian.Appdata, customer *elarian.Customer, cb elarian.NotificationCallBack) error {
+			if atomic.AddInt32(&handled, 1) == 1 {
+				return errors.New("handler failed")
+			}
+			return reply(ctx, svc, notification, appData, customer, cb)
+		})
+
+		notify(t, server, "session_1")
+		assert.Error(t, <-errs)
+		assert.Equal(t, "session_1", notify(t, server, "session_1").GetMessage().GetBody().GetUssd().GetText())
+		assert.Empty(t, notify(t, server, "session_1").GetMessage().GetBody().GetUssd().GetText())
+		assert.Equal(t, int32(2), atomic.LoadInt32(&handled))
+	})
+}
+
+func Test_NotificationJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	connect := func(t *testing.T) (*standInServer, elarian.Elarian) {
+		server := newStandInServer(t, elarian.TransportTCP)
+		opts, conOpts := server.Options()
+		opts.ReplyTimeout = time.Millisecond * 50
+		opts.JournalPath = filepath.Join(dir, "notifications")
+		service, err := elarian.Connect(opts, conOpts)
+		if err != nil {
+			t.Fatalf("Error %v", err)
+		}
+		server.WaitForClient(t)
+		return server, service
+	}
+
+	server, service := connect(t)
+	service.InitializeNotificationStream()
+	release := make(chan struct{})
+	service.OnReceivedUssdSession(func(ctx context.Context, svc elarian.Elarian, notification *elarian.UssdSessionNotification, appData *elarian.Appdata, customer *elarian.Customer, cb elarian.NotificationCallBack) error {
+		if notification.SessionID == "session_unfinished" {
+			<-release
+		}
+		cb(nil, nil)
+		return nil
+	})
+
+	// the unfinished notification times out so elarian is no longer waiting for it when the service stops
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	for _, sessionID := range []string{"session_done", "session_unfinished"} {
+		if _, err := server.Notify(ctx, ussdNotification("el_cst_1", sessionID, "1")); err != nil {
+			t.Fatalf("Error %v", err)
+		}
+	}
+	service.Disconnect()
+	close(release)
+
+	t.Run("It should replay unfinished notifications when the service starts", func(t *testing.T) {
+		_, service := connect(t)
+		defer service.Disconnect()
+
+		replayed := make(chan string, 2)
+		service.OnReceivedUssdSession(func(ctx context.Context, svc elarian.Elarian, notification *elarian.UssdSessionNotification, appData *elarian.Appdata, customer *elarian.Customer, cb elarian.NotificationCallBack) error {
+			assert.Equal(t, "el_cst_1", elarian.CustomerIDFromContext(ctx))
+			replayed <- notification.SessionID
+			cb(nil, nil)
+			return nil
+		})
+		service.InitializeNotificationStream()
+
+		assert.Equal(t, "session_unfinished", <-replayed)
+		select {
+		case sessionID := <-replayed:
+			t.Fatalf("%s was replayed", sessionID)
+		case <-time.After(time.Millisecond * 100):
+		}
+	})
+
+	t.Run("It should not replay notifications that were completed by a replay", func(t *testing.T) {
+		_, service := connect(t)
+		defer service.Disconnect()
+
+		replayed := make(chan string, 1)
+		service.OnReceivedUssdSession(func(ctx context.Context, svc elarian.Elarian, notification *elarian.UssdSessionNotification, appData *elarian.Appdata, customer *elarian.Customer, cb elarian.NotificationCallBack) error {
+			replayed <- notification.SessionID
+			return nil
+		})
+		service.InitializeNotificationStream()
+
+		select {
+		case sessionID := <-replayed:
+			t.Fatalf("%s was replayed", sessionID)
+		case <-time.After(time.Millisecond * 100):
+		}
+	})
+}
+
+func Test_NotificationJournalCompletion(t *testing.T) {
+	connect := func(t *testing.T, path string, policy elarian.BackpressurePolicy) (*standInServer, elarian.Elarian) {
+		server := newStandInServer(t, elarian.TransportTCP)
+		opts, conOpts := server.Options()
+		opts.ReplyTimeout = time.Second * 5
+		opts.QueueSize = 1
+		opts.Backpressure = policy
+		opts.JournalPath = path
+		opts.DefaultReply = func(notification elarian.Notification, customerID string) (elarian.IsOutBoundMessageBody, *elarian.Appdata) {
+			return &elarian.UssdMenu{Text: customerID}, nil
+		}
+		service, err := elarian.Connect(opts, conOpts)
+		if err != nil {
+			t.Fatalf("Error %v", err)
+		}
+		server.WaitForClient(t)
+		return server, service
+	}
+	journalPath := func(t *testing.T) string {
+		dir, err := ioutil.TempDir("", "journal")
+		if err != nil {
+			t.Fatalf("Error %v", err)
+		}
+		t.Cleanup(func() { os.RemoveAll(dir) })
+		return filepath.Join(dir, "notifications")
+	}
+	// crash copies the journal as it would be found if the process stopped now and returns the path of the copy
+	crash := func(t *testing.T, path string) string {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Error %v", err)
+		}
+		if err := ioutil.WriteFile(path+".crashed", data, 0600); err != nil {
+			t.Fatalf("Error %v", err)
+		}
+		return path + ".crashed"
+	}
+	// replayed starts the service with the journal at path and returns the sessions replayed to its handlers
+	replayed := func(t *testing.T, path string) []string {
+		_, service := connect(t, path, elarian.BackpressureBlock)
+		defer service.Disconnect()
+		sessions := make(chan string, 4)
+		service.OnReceivedUssdSession(func(ctx context.Context, svc elarian.Elarian, notification *elarian.UssdSessionNotification, appData *elarian.Appdata, customer *elarian.Customer, cb elarian.NotificationCallBack) error {
+			sessions <- notification.SessionID
+			cb(nil, nil)
+			return nil
+		})
+		service.InitializeNotificationStream()
+		var result []string
+		for {
+			select {
+			case sessionID := <-sessions:
+				result = append(result, sessionID)
+			case <-time.After(time.Millisecond * 200):
+				return result
+			}
+		}
+	}
+
+	t.Run("It should replay notifications whose handlers returned without replying", func(t *testing.T) {
+		path := journalPath(t)
+		server, service := connect(t, path, elarian.BackpressureBlock)
+		service.InitializeNotificationStream()
+		callbacks := make(chan elarian.NotificationCallBack, 1)
+		service.OnReceivedUssdSession(func(ctx context.Context, svc elarian.Elarian, notification *elarian.UssdSessionNotification, appData *elarian.Appdata, customer *elarian.Customer, cb elarian.NotificationCallBack) error {
+			callbacks <- cb
+			return nil
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+		notified := make(chan error, 1)
+		go func() {
+			_, err := server.Notify(ctx, ussdNotification("el_cst_1", "session_async", "1"))
+			notified <- err
+		}()
+		// the handler has returned, its reply is sent later from another goroutine
+		cb := <-callbacks
+		time.Sleep(time.Millisecond * 50)
+		crashed := crash(t, path)
+		cb(nil, nil)
+		assert.NoError(t, <-notified)
+		service.Disconnect()
+
+		assert.Equal(t, []string{"session_async"}, replayed(t, crashed))
+		assert.Empty(t, replayed(t, path))
+	})
+
+	t.Run("It should compact the journal while a notification is pending", func(t *testing.T) {
+		path := journalPath(t)
+		server, service := connect(t, path, elarian.BackpressureBlock)
+		defer service.Disconnect()
+		service.InitializeNotificationStream()
+		callbacks := make(chan elarian.NotificationCallBack, 1)
+		service.OnReceivedUssdSession(func(ctx context.Context, svc elarian.Elarian, notification *elarian.UssdSessionNotification, appData *elarian.Appdata, customer *elarian.Customer, cb elarian.NotificationCallBack) error {
+			if notification.SessionID == "session_pending" {
+				callbacks <- cb
+				return nil
+			}
+			cb(nil, nil)
+			return nil
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+		defer cancel()
+		notified := make(chan error, 1)
+		go func() {
+			_, err := server.Notify(ctx, ussdNotification("el_cst_1", "session_pending", "1"))
+			notified <- err
+		}()
+		cb := <-callbacks
+		// every notification appends two records, enough for the journal to be compacted
+		for i := 0; i < 600; i++ {
+			if _, err := server.Notify(ctx, ussdNotification("el_cst_2", fmt.Sprintf("session_%d", i), "1")); err != nil {
+				t.Fatalf("Error %v", err)
+			}
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Error %v", err)
+		}
+		assert.Less(t, strings.Count(string(data), "\n"), 1000)
+		crashed := crash(t, path)
+		cb(nil, nil)
+		assert.NoError(t, <-notified)
+		service.Disconnect()
+
+		assert.Equal(t, []string{"session_pending"}, replayed(t, crashed))
+	})
+
+	for _, policy := range []elarian.BackpressurePolicy{elarian.BackpressureDefaultReply, elarian.BackpressureReject} {
+		policy := policy
+		t.Run(fmt.Sprintf("It should not replay notifications that overflowed with backpressure policy %d", policy), func(t *testing.T) {
+			path := journalPath(t)
+			server, service := connect(t, path, policy)
+			defer service.Disconnect()
+
+			// the notification stream is not initialized yet so the first notification stays queued and the second overflows
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+			defer cancel()
+			overflowed := make(chan string, 2)
+			for _, customerID := range []string{"el_cst_1", "el_cst_2"} {
+				customerID := customerID
+				go func() {
+					reply, err := server.Notify(ctx, ussdNotification(customerID, "session_"+customerID, "1"))
+					if err == nil && reply.GetMessage() != nil {
+						customerID = reply.GetMessage().GetBody().GetUssd().GetText()
+					}
+					overflowed <- customerID
+				}()
+			}
+			customerID := <-overflowed
+			crashed := crash(t, path)
+			service.OnReceivedUssdSession(func(ctx context.Context, svc elarian.Elarian, notification *elarian.UssdSessionNotification, appData *elarian.Appdata, customer *elarian.Customer, cb elarian.NotificationCallBack) error {
+				cb(nil, nil)
+				return nil
+			})
+			service.InitializeNotificationStream()
+			<-overflowed
+
+			queued := map[string]string{"el_cst_1": "session_el_cst_2", "el_cst_2": "session_el_cst_1"}[customerID]
+			assert.Equal(t, []string{queued}, replayed(t, crashed))
+		})
+	}
+}
+
+func Test_NotificationRecording(t *testing.T) {
+	formats := []struct {
+		name   string
+		format elarian.RecordFormat
+		other  elarian.RecordFormat
+	}{
+		{"json", elarian.RecordFormatJSON, elarian.RecordFormatProtobuf},
+		{"protobuf", elarian.RecordFormatProtobuf, elarian.RecordFormatJSON},
+	}
+	for _, format := range formats {
+		format := format
+		t.Run(format.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "recording")
+			if err != nil {
+				t.Fatalf("Error %v", err)
+			}
+			defer os.RemoveAll(dir)
+			path := filepath.Join(dir, "recording")
+
+			server := newStandInServer(t, elarian.TransportTCP)
+			opts, conOpts := server.Options()
+			opts.RecordPath = path
+			opts.RecordFormat = format.format
+			service, err := elarian.Connect(opts, conOpts)
+			if err != nil {
+				t.Fatalf("Error %v", err)
+			}
+			server.WaitForClient(t)
+			service.InitializeNotificationStream()
+			service.OnReceivedUssdSession(func(ctx context.Context, svc elarian.Elarian, notification *elarian.UssdSessionNotification, appData *elarian.Appdata, customer *elarian.Customer, cb elarian.NotificationCallBack) error {
+				cb(nil, nil)
+				return nil
+			})
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+			defer cancel()
+			for i := 0; i < 3; i++ {
+				if _, err := server.Notify(ctx, ussdNotification(fmt.Sprintf("el_cst_%d", i), fmt.Sprintf("session_%d", i), "1")); err != nil {
+					t.Fatalf("Error %v", err)
+				}
+			}
+			service.Disconnect()
+
+			t.Run("It should record every notification frame", func(t *testing.T) {
+				recorded, err := elarian.ReadRecording(path)
+				if err != nil {
+					t.Fatalf("Error %v", err)
+				}
+				if !assert.Len(t, recorded, 3) {
+					return
+				}
+				for i, notification := range recorded {
+					assert.Equal(t, "customer.received_message", notification.Entry)
+					assert.Equal(t, fmt.Sprintf("el_cst_%d", i), notification.CustomerID)
+					assert.False(t, notification.Simulator)
+					assert.False(t, notification.ReceivedAt.IsZero())
+					assert.Contains(t, string(notification.Frame), fmt.Sprintf("session_%d", i))
+				}
+			})
+
+			t.Run("It should replay the recording to handlers without elarian", func(t *testing.T) {
+				replayer := elarian.NewReplayer(&elarian.Options{})
+				defer replayer.Disconnect()
+
+				var sessions []string
+				replayer.OnReceivedUssdSession(func(ctx context.Context, svc elarian.Elarian, notification *elarian.UssdSessionNotification, appData *elarian.Appdata, customer *elarian.Customer, cb elarian.NotificationCallBack) error {
+					sessions = append(sessions, customer.ID+":"+notification.SessionID)
+					_, err := svc.GenerateAuthToken(ctx)
+					assert.True(t, errors.Is(err, elarian.ErrUnreachable))
+					cb(nil, nil)
+					return nil
+				})
+
+				assert.NoError(t, replayer.Replay(ctx, path))
+				assert.Equal(t, []string{"el_cst_0:session_0", "el_cst_1:session_1", "el_cst_2:session_2"}, sessions)
+			})
+
+			t.Run("It should not append to a recording in another format", func(t *testing.T) {
+				opts, conOpts := server.Options()
+				opts.RecordPath = path
+				opts.RecordFormat = format.other
+				service, err := elarian.Connect(opts, conOpts)
+				if err == nil {
+					service.Disconnect()
+				}
+				assert.Error(t, err)
+			})
+		})
+	}
+}
+
+func Test_NotificationEnvelope(t *testing.T) {
+	server := newStandInServer(t, elarian.TransportTCP)
+	service, err := elarian.Connect(server.Options())
+	if err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	defer service.Disconnect()
+	server.WaitForClient(t)
+	service.InitializeNotificationStream()
+
+	envelopes := make(chan *elarian.NotificationEnvelope, 1)
+	service.OnReceivedUssdSession(func(ctx context.Context, svc elarian.Elarian, notification *elarian.UssdSessionNotification, appData *elarian.Appdata, customer *elarian.Customer, cb elarian.NotificationCallBack) error {
+		envelope, ok := elarian.EnvelopeFromContext(ctx)
+		assert.True(t, ok)
+		envelopes <- envelope
+		cb(nil, nil)
+		return nil
+	})
+
+	createdAt := time.Date(2021, time.March, 1, 8, 30, 0, 0, time.UTC)
+	notification := ussdNotification("el_cst_1", "session_1", "1")
+	notification.GetCustomer().CreatedAt = timestamppb.New(createdAt)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	if _, err := server.Notify(ctx, notification); err != nil {
+		t.Fatalf("Error %v", err)
+	}
+
+	t.Run("It should carry the whole notification envelope", func(t *testing.T) {
+		envelope := <-envelopes
+		assert.Equal(t, "test_org", envelope.OrgID)
+		assert.Equal(t, "test_app", envelope.AppID)
+		assert.Equal(t, "el_cst_1", envelope.CustomerID)
+		assert.Empty(t, envelope.PurseID)
+		assert.True(t, createdAt.Equal(envelope.CreatedAt))
+		assert.Equal(t, "session_1", envelope.Raw.GetCustomer().GetReceivedMessage().GetSessionId().GetValue())
+		assert.Nil(t, envelope.Simulator)
+	})
+}
+
+func reminderNotification(customerID, workID string) *hera.ServerToAppNotification {
+	return &hera.ServerToAppNotification{
+		Entry: &hera.ServerToAppNotification_Customer{
+			Customer: &hera.ServerToAppCustomerNotification{
+				OrgId:      "test_org",
+				AppId:      "test_app",
+				CustomerId: customerID,
+				Entry: &hera.ServerToAppCustomerNotification_Reminder{
+					Reminder: &hera.ReminderNotification{
+						Reminder: &hera.CustomerReminder{Key: "reminder", Payload: wrapperspb.String("payload")},
+						WorkId:   wrapperspb.String(workID),
+					},
+				},
+			},
+		},
+	}
+}
+
+func Test_CustomerLookup(t *testing.T) {
+	// remind connects to a stand in server that knows the number of every customer, sends it reminders for customerIDs one after the other
+	// and returns the customer numbers the reminder handler got along with the number of customer states the sdk fetched
+	remind := func(t *testing.T, lookup elarian.CustomerLookup, before func(service elarian.Elarian, server *standInServer), customerIDs ...string) ([]string, int) {
+		server := newStandInServer(t, elarian.TransportTCP)
+		server.CommandHandler = func(command *hera.AppToServerCommand) *hera.AppToServerCommandReply {
+			customerID := command.GetGetCustomerState().GetCustomerId()
+			return &hera.AppToServerCommandReply{
+				Entry: &hera.AppToServerCommandReply_GetCustomerState{
+					GetCustomerState: &hera.GetCustomerStateReply{
+						Status: true,
+						Data: &hera.CustomerStateReplyData{
+							CustomerId: customerID,
+							ActivityState: &hera.ActivityState{
+								CustomerNumbers: []*hera.CustomerNumber{{Number: "+254" + customerID}},
+							},
+						},
+					},
+				},
+			}
+		}
+		opts, conOpts := server.Options()
+		opts.CustomerLookup = lookup
+		service, err := elarian.Connect(opts, conOpts)
+		if err != nil {
+			t.Fatalf("Error %v", err)
+		}
+		defer service.Disconnect()
+		server.WaitForClient(t)
+		service.InitializeNotificationStream()
+
+		var numbers []string
+		service.OnReminder(func(ctx context.Context, svc elarian.Elarian, notification *elarian.ReminderNotification, appData *elarian.Appdata, customer *elarian.Customer, cb elarian.NotificationCallBack) error {
+			if customer.CustomerNumber == nil {
+				numbers = append(numbers, "")
+			} else {
+				numbers = append(numbers, customer.CustomerNumber.Number)
+			}
+			cb(nil, nil)
+			return nil
+		})
+		if before != nil {
+			before(service, server)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+		for i, customerID := range customerIDs {
+			if _, err := server.Notify(ctx, reminderNotification(customerID, fmt.Sprintf("work_%d", i))); err != nil {
+				t.Fatalf("Error %v", err)
+			}
+		}
+		fetched := 0
+		for _, command := range server.Commands() {
+			if command.GetGetCustomerState() != nil {
+				fetched++
+			}
+		}
+		return numbers, fetched
+	}
+
+	t.Run("It should fetch the number of every customer once when reminders are cached", func(t *testing.T) {
+		numbers, fetched := remind(t, elarian.CustomerLookupCached, nil, "el_cst_1", "el_cst_2", "el_cst_1", "el_cst_1")
+		assert.Equal(t, []string{"+254el_cst_1", "+254el_cst_2", "+254el_cst_1", "+254el_cst_1"}, numbers)
+		assert.Equal(t, 2, fetched)
+	})
+
+	t.Run("It should not fetch customer states when the lookup is lazy or off", func(t *testing.T) {
+		for _, lookup := range []elarian.CustomerLookup{elarian.CustomerLookupLazy, elarian.CustomerLookupOff} {
+			numbers, fetched := remind(t, lookup, nil, "el_cst_1", "el_cst_1")
+			assert.Equal(t, []string{"", ""}, numbers)
+			assert.Zero(t, fetched)
+		}
+	})
+
+	t.Run("It should share numbers from other notifications with reminders", func(t *testing.T) {
+		before := func(service elarian.Elarian, server *standInServer) {
+			service.OnReceivedUssdSession(func(ctx context.Context, svc elarian.Elarian, notification *elarian.UssdSessionNotification, appData *elarian.Appdata, customer *elarian.Customer, cb elarian.NotificationCallBack) error {
+				cb(nil, nil)
+				return nil
+			})
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+			defer cancel()
+			if _, err := server.Notify(ctx, ussdNotification("el_cst_1", "session_1", "1")); err != nil {
+				t.Fatalf("Error %v", err)
+			}
+		}
+		numbers, fetched := remind(t, elarian.CustomerLookupLazy, before, "el_cst_1", "el_cst_2")
+		assert.Equal(t, []string{"+254700000000", ""}, numbers)
+		assert.Zero(t, fetched)
+	})
+
+	t.Run("It should not share changes handlers make to customer numbers", func(t *testing.T) {
+		before := func(service elarian.Elarian, server *standInServer) {
+			service.OnReceivedUssdSession(func(ctx context.Context, svc elarian.Elarian, notification *elarian.UssdSessionNotification, appData *elarian.Appdata, customer *elarian.Customer, cb elarian.NotificationCallBack) error {
+				customer.CustomerNumber.Number = "+254711111111"
+				cb(nil, nil)
+				return nil
+			})
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+			defer cancel()
+			if _, err := server.Notify(ctx, ussdNotification("el_cst_1", "session_1", "1")); err != nil {
+				t.Fatalf("Error %v", err)
+			}
+		}
+		numbers, _ := remind(t, elarian.CustomerLookupLazy, before, "el_cst_1")
+		assert.Equal(t, []string{"+254700000000"}, numbers)
+	})
+
+	t.Run("It should resolve the customer number on demand", func(t *testing.T) {
+		before := func(service elarian.Elarian, server *standInServer) {
+			service.OnReminder(func(ctx context.Context, svc elarian.Elarian, notification *elarian.ReminderNotification, appData *elarian.Appdata, customer *elarian.Customer, cb elarian.NotificationCallBack) error {
+				_, err := customer.ResolveCustomerNumber(ctx)
+				return err
+			})
+		}
+		numbers, fetched := remind(t, elarian.CustomerLookupLazy, before, "el_cst_1", "el_cst_1")
+		// the first reminder resolves the number after the first handler ran, the second finds it in the cache
+		assert.Equal(t, []string{"", "+254el_cst_1"}, numbers)
+		assert.Equal(t, 1, fetched)
+	})
+}
@@ -0,0 +1,122 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	elarian "github.com/elarianltd/go-sdk"
+	hera "github.com/elarianltd/go-sdk/com_elarian_hera_proto"
+	"github.com/rsocket/rsocket-go/core"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// rsocketError is an rsocket error frame the stand in server fails commands with
+type rsocketError struct {
+	code core.ErrorCode
+}
+
+func (e rsocketError) Error() string             { return e.code.String() }
+func (e rsocketError) ErrorCode() core.ErrorCode { return e.code }
+func (e rsocketError) ErrorData() []byte         { return []byte(e.code.String()) }
+
+func Test_RetryPolicy(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		failures int
+		code     core.ErrorCode
+	)
+	// failWith fails the next n commands with an rsocket error of the given code
+	failWith := func(errorCode core.ErrorCode, n int) {
+		mu.Lock()
+		defer mu.Unlock()
+		code, failures = errorCode, n
+	}
+
+	server := newStandInServer(t, elarian.TransportTCP)
+	server.CommandError = func(command *hera.AppToServerCommand) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if failures == 0 {
+			return nil
+		}
+		failures--
+		return rsocketError{code: code}
+	}
+	server.CommandHandler = func(command *hera.AppToServerCommand) *hera.AppToServerCommandReply {
+		if command.GetSendMessage() == nil {
+			return &hera.AppToServerCommandReply{}
+		}
+		return &hera.AppToServerCommandReply{Entry: &hera.AppToServerCommandReply_SendMessage{SendMessage: &hera.SendMessageReply{
+			CustomerId: wrapperspb.String("el_cst_1"),
+			MessageId:  wrapperspb.String("el_msg_1"),
+		}}}
+	}
+	opts, conOpts := server.Options()
+	opts.RetryPolicy = &elarian.RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond, MaxBackoff: time.Millisecond * 5}
+	service, err := elarian.Connect(opts, conOpts)
+	if err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	defer service.Disconnect()
+	server.WaitForClient(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	number := &elarian.CustomerNumber{Number: "+254700000000", Provider: elarian.CustomerNumberProviderCellular}
+	channel := &elarian.MessagingChannelNumber{Number: "21356", Channel: elarian.MessagingChannelSms}
+	sent := func() int { return len(server.Commands()) }
+
+	t.Run("It should retry idempotent commands after transient errors", func(t *testing.T) {
+		before := sent()
+		failWith(core.ErrorCodeCanceled, 2)
+		_, err := service.GetCustomerState(ctx, elarian.CustomerID("el_cst_1"))
+		assert.Nil(t, err)
+		assert.Equal(t, 3, sent()-before)
+	})
+
+	t.Run("It should give up after MaxAttempts", func(t *testing.T) {
+		before := sent()
+		failWith(core.ErrorCodeCanceled, 5)
+		_, err := service.GetCustomerState(ctx, elarian.CustomerID("el_cst_1"))
+		assert.NotNil(t, err)
+		assert.Equal(t, 3, sent()-before)
+		failWith(0, 0)
+	})
+
+	t.Run("It should not retry application errors", func(t *testing.T) {
+		before := sent()
+		failWith(core.ErrorCodeApplicationError, 1)
+		_, err := service.GetCustomerState(ctx, elarian.CustomerID("el_cst_1"))
+		assert.NotNil(t, err)
+		assert.False(t, elarian.IsRetryable(err))
+		assert.Equal(t, 1, sent()-before)
+	})
+
+	t.Run("It should not retry commands that are not idempotent after elarian may have received them", func(t *testing.T) {
+		before := sent()
+		failWith(core.ErrorCodeCanceled, 1)
+		_, err := service.SendMessage(ctx, number, channel, elarian.TextMessage("Hello World"))
+		assert.NotNil(t, err)
+		assert.True(t, elarian.IsRetryable(err))
+		assert.Equal(t, 1, sent()-before)
+		failWith(0, 0)
+	})
+
+	t.Run("It should retry commands that are not idempotent when elarian rejected them", func(t *testing.T) {
+		before := sent()
+		failWith(core.ErrorCodeRejected, 1)
+		_, err := service.SendMessage(ctx, number, channel, elarian.TextMessage("Hello World"))
+		assert.Nil(t, err)
+		assert.Equal(t, 2, sent()-before)
+	})
+
+	t.Run("It should classify transient errors", func(t *testing.T) {
+		assert.True(t, elarian.IsRetryable(&elarian.ConnectionError{Kind: elarian.ErrUnreachable, Err: errors.New("connection reset")}))
+		assert.False(t, elarian.IsRetryable(context.Canceled))
+		assert.False(t, elarian.IsRetryable(errors.New("invalid customer")))
+	})
+}
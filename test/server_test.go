@@ -0,0 +1,347 @@
+package test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	elarian "github.com/elarianltd/go-sdk"
+	hera "github.com/elarianltd/go-sdk/com_elarian_hera_proto"
+	"github.com/rsocket/rsocket-go"
+	"github.com/rsocket/rsocket-go/payload"
+	"github.com/rsocket/rsocket-go/rx/mono"
+	"google.golang.org/protobuf/proto"
+)
+
+// standInServer is a local rsocket server that stands in for elarian during tests.
+// It records the connection metadata sent by the sdk, answers commands and can push notifications to connected clients.
+type standInServer struct {
+	host      string
+	port      int
+	transport elarian.Transport
+	roots     *x509.CertPool
+	cancel    context.CancelFunc
+
+	mu       sync.Mutex
+	setups   []*hera.AppConnectionMetadata
+	sockets  []rsocket.CloseableRSocket
+	commands []*hera.AppToServerCommand
+	metadata [][]byte
+	onSocket chan struct{}
+
+	// SetupError rejects every client setup with the given error when it is set
+	SetupError error
+
+	// CommandError, when set, fails a command with the error it returns instead of replying. The command is recorded either way
+	CommandError func(command *hera.AppToServerCommand) error
+
+	// CommandHandler builds the reply sent for a command. An empty reply is sent when it is nil
+	CommandHandler func(command *hera.AppToServerCommand) *hera.AppToServerCommandReply
+}
+
+func freePort(t *testing.T) int {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error finding a free port: %v", err)
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func newStandInServer(t *testing.T, transport elarian.Transport) *standInServer {
+	return startStandInServer(t, transport, nil, false)
+}
+
+// newResumableStandInServer starts a stand in server that lets clients resume their sessions after their transport is lost
+func newResumableStandInServer(t *testing.T, transport elarian.Transport) *standInServer {
+	return startStandInServer(t, transport, nil, true)
+}
+
+// newTLSStandInServer starts a stand in server that only accepts tls connections, its certificate is signed by a self signed ca that Options trusts
+func newTLSStandInServer(t *testing.T, transport elarian.Transport) *standInServer {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "stand in elarian"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Error creating certificate: %v", err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Error parsing certificate: %v", err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(certificate)
+
+	server := startStandInServer(t, transport, &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+		MinVersion:   tls.VersionTLS12,
+	}, false)
+	server.roots = roots
+	return server
+}
+
+func startStandInServer(t *testing.T, transport elarian.Transport, tlsConfig *tls.Config, resume bool) *standInServer {
+	ctx, cancel := context.WithCancel(context.Background())
+	server := &standInServer{
+		host:      "127.0.0.1",
+		port:      freePort(t),
+		transport: transport,
+		cancel:    cancel,
+		onSocket:  make(chan struct{}, 64),
+	}
+	t.Cleanup(server.Close)
+
+	acceptor := func(ctx context.Context, setup payload.SetupPayload, socket rsocket.CloseableRSocket) (rsocket.RSocket, error) {
+		metadata := &hera.AppConnectionMetadata{}
+		if err := proto.Unmarshal(setup.Data(), metadata); err != nil {
+			return nil, err
+		}
+		server.mu.Lock()
+		if server.SetupError != nil {
+			server.mu.Unlock()
+			return nil, server.SetupError
+		}
+		server.setups = append(server.setups, metadata)
+		server.sockets = append(server.sockets, socket)
+		server.mu.Unlock()
+		server.onSocket <- struct{}{}
+
+		return rsocket.NewAbstractSocket(
+			rsocket.RequestResponse(func(msg payload.Payload) mono.Mono {
+				command := &hera.AppToServerCommand{}
+				if err := proto.Unmarshal(msg.Data(), command); err != nil {
+					return mono.Error(err)
+				}
+				server.mu.Lock()
+				server.commands = append(server.commands, command)
+				metadata, _ := msg.Metadata()
+				server.metadata = append(server.metadata, append([]byte{}, metadata...))
+				handler, fail := server.CommandHandler, server.CommandError
+				server.mu.Unlock()
+
+				if fail != nil {
+					if err := fail(command); err != nil {
+						return mono.Error(err)
+					}
+				}
+
+				reply := &hera.AppToServerCommandReply{}
+				if handler != nil {
+					reply = handler(command)
+				}
+				data, err := proto.Marshal(reply)
+				if err != nil {
+					return mono.Error(err)
+				}
+				return mono.Just(payload.New(data, nil))
+			}),
+		), nil
+	}
+
+	started := make(chan struct{})
+	receiver := rsocket.Receive().OnStart(func() { close(started) })
+	if resume {
+		receiver = receiver.Resume()
+	}
+	builder := receiver.Acceptor(acceptor)
+	var start rsocket.Start
+	if transport == elarian.TransportWebSocket {
+		start = builder.Transport(rsocket.WebsocketServer().SetAddr(server.addr()).SetTLSConfig(tlsConfig).Build())
+	} else {
+		start = builder.Transport(rsocket.TCPServer().SetAddr(server.addr()).SetTLSConfig(tlsConfig).Build())
+	}
+	go start.Serve(ctx)
+
+	select {
+	case <-started:
+	case <-time.After(time.Second * 5):
+		t.Fatal("stand in server did not start")
+	}
+	return server
+}
+
+func (s *standInServer) addr() string {
+	return net.JoinHostPort(s.host, strconv.Itoa(s.port))
+}
+
+// Options returns sdk options that connect to the stand in server, over tls with the server's ca as the only root when it was started with newTLSStandInServer
+func (s *standInServer) Options() (*elarian.Options, *elarian.ConnectionOptions) {
+	opts := &elarian.Options{
+		APIKey:             "test_api_key",
+		OrgID:              "test_org",
+		AppID:              "test_app",
+		AllowNotifications: true,
+	}
+	conOpts := &elarian.ConnectionOptions{
+		Host:      s.host,
+		Port:      s.port,
+		Transport: s.transport,
+		RootCAs:   s.roots,
+		Insecure:  s.roots == nil,
+	}
+	return opts, conOpts
+}
+
+// WaitForClient blocks until a client has completed its setup
+func (s *standInServer) WaitForClient(t *testing.T) {
+	select {
+	case <-s.onSocket:
+	case <-time.After(time.Second * 5):
+		t.Fatal("no client connected to the stand in server")
+	}
+}
+
+// Setups returns the connection metadata received from every client
+func (s *standInServer) Setups() []*hera.AppConnectionMetadata {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*hera.AppConnectionMetadata{}, s.setups...)
+}
+
+// Commands returns every command received from clients
+func (s *standInServer) Commands() []*hera.AppToServerCommand {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*hera.AppToServerCommand{}, s.commands...)
+}
+
+// CommandMetadata returns the rsocket metadata of every command received from clients
+func (s *standInServer) CommandMetadata() [][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([][]byte{}, s.metadata...)
+}
+
+// Notify pushes a notification to the most recently connected client and returns its reply
+func (s *standInServer) Notify(ctx context.Context, notification *hera.ServerToAppNotification) (*hera.ServerToAppNotificationReply, error) {
+	reply, _, err := s.NotifyWithMetadata(ctx, notification, nil)
+	return reply, err
+}
+
+// NotifyWithMetadata pushes a notification with the given rsocket metadata and returns its reply along with the reply's metadata
+func (s *standInServer) NotifyWithMetadata(ctx context.Context, notification *hera.ServerToAppNotification, metadata []byte) (*hera.ServerToAppNotificationReply, []byte, error) {
+	s.mu.Lock()
+	socket := s.sockets[len(s.sockets)-1]
+	s.mu.Unlock()
+
+	data, err := proto.Marshal(notification)
+	if err != nil {
+		return nil, nil, err
+	}
+	res, err := socket.RequestResponse(payload.New(data, metadata)).Block(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	reply := &hera.ServerToAppNotificationReply{}
+	if err := proto.Unmarshal(res.Data(), reply); err != nil {
+		return nil, nil, err
+	}
+	replyMetadata, _ := res.Metadata()
+	return reply, append([]byte{}, replyMetadata...), nil
+}
+
+// DropClients closes the server side of every connected client socket
+func (s *standInServer) DropClients() {
+	s.mu.Lock()
+	sockets := s.sockets
+	s.sockets = nil
+	s.mu.Unlock()
+	for _, socket := range sockets {
+		socket.Close()
+	}
+}
+
+// Close stops the server
+func (s *standInServer) Close() {
+	s.cancel()
+}
+
+// standInProxy relays tcp connections to a stand in server. Cutting them loses the transport of the clients without closing their rsocket sessions
+type standInProxy struct {
+	listener net.Listener
+
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func newStandInProxy(t *testing.T, server *standInServer) *standInProxy {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error starting proxy: %v", err)
+	}
+	proxy := &standInProxy{listener: listener}
+	t.Cleanup(proxy.Close)
+	go proxy.serve(server.addr())
+	return proxy
+}
+
+func (p *standInProxy) serve(addr string) {
+	for {
+		client, err := p.listener.Accept()
+		if err != nil {
+			return
+		}
+		server, err := net.Dial("tcp", addr)
+		if err != nil {
+			client.Close()
+			continue
+		}
+		p.mu.Lock()
+		p.conns = append(p.conns, client, server)
+		p.mu.Unlock()
+		go relay(client, server)
+		go relay(server, client)
+	}
+}
+
+func relay(dst, src net.Conn) {
+	_, _ = io.Copy(dst, src)
+	dst.Close()
+	src.Close()
+}
+
+// Port returns the port clients connect to the proxy on
+func (p *standInProxy) Port() int {
+	return p.listener.Addr().(*net.TCPAddr).Port
+}
+
+// Cut closes every relayed connection
+func (p *standInProxy) Cut() {
+	p.mu.Lock()
+	conns := p.conns
+	p.conns = nil
+	p.mu.Unlock()
+	for _, conn := range conns {
+		conn.Close()
+	}
+}
+
+// Close stops accepting connections and cuts the relayed ones
+func (p *standInProxy) Close() {
+	p.listener.Close()
+	p.Cut()
+}
@@ -0,0 +1,128 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	elarian "github.com/elarianltd/go-sdk"
+	hera "github.com/elarianltd/go-sdk/com_elarian_hera_proto"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// failedStatusReply replies to commands with a failed status
+func failedStatusReply(command *hera.AppToServerCommand) *hera.AppToServerCommandReply {
+	switch {
+	case command.GetSendMessage() != nil:
+		return &hera.AppToServerCommandReply{Entry: &hera.AppToServerCommandReply_SendMessage{SendMessage: &hera.SendMessageReply{
+			Status:      hera.MessageDeliveryStatus_MESSAGE_DELIVERY_STATUS_NO_CONSENT,
+			Description: "customer has not opted in",
+			CustomerId:  wrapperspb.String("el_cst_1"),
+			MessageId:   wrapperspb.String("el_msg_1"),
+		}}}
+	case command.GetInitiatePayment() != nil:
+		return &hera.AppToServerCommandReply{Entry: &hera.AppToServerCommandReply_InitiatePayment{InitiatePayment: &hera.InitiatePaymentReply{
+			Status:          hera.PaymentStatus_PAYMENT_STATUS_INSUFFICIENT_FUNDS,
+			Description:     "insufficient funds in wallet",
+			DebitCustomerId: wrapperspb.String("el_cst_2"),
+		}}}
+	case command.GetUpdateMessagingConsent() != nil:
+		return &hera.AppToServerCommandReply{Entry: &hera.AppToServerCommandReply_UpdateMessagingConsent{UpdateMessagingConsent: &hera.UpdateMessagingConsentReply{
+			Status:      hera.MessagingConsentUpdateStatus_MESSAGING_CONSENT_UPDATE_STATUS_INVALID_CHANNEL_NUMBER,
+			Description: "unknown channel",
+		}}}
+	default:
+		return &hera.AppToServerCommandReply{Entry: &hera.AppToServerCommandReply_UpdateCustomerState{UpdateCustomerState: &hera.UpdateCustomerStateReply{
+			Status:      false,
+			Description: "customer not found",
+			CustomerId:  wrapperspb.String("el_cst_3"),
+		}}}
+	}
+}
+
+func Test_StatusErrors(t *testing.T) {
+	server := newStandInServer(t, elarian.TransportTCP)
+	server.CommandHandler = failedStatusReply
+	opts, conOpts := server.Options()
+	opts.StatusErrors = true
+	service, err := elarian.Connect(opts, conOpts)
+	if err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	defer service.Disconnect()
+	server.WaitForClient(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	number := &elarian.CustomerNumber{Number: "+254700000000", Provider: elarian.CustomerNumberProviderCellular}
+	channel := &elarian.MessagingChannelNumber{Number: "21356", Channel: elarian.MessagingChannelSms}
+
+	t.Run("It should return failed delivery statuses as errors", func(t *testing.T) {
+		reply, err := service.SendMessage(ctx, number, channel, elarian.TextMessage("Hello World"))
+		assert.Nil(t, reply)
+		assert.True(t, errors.Is(err, elarian.ErrMessageDeliveryNoConsent))
+		assert.True(t, errors.Is(err, elarian.ErrCommandFailed))
+		var commandErr *elarian.CommandError
+		if assert.True(t, errors.As(err, &commandErr)) {
+			assert.Equal(t, "send_message", commandErr.Command)
+			assert.Equal(t, int32(elarian.MessageDeliveryStatusNoConsent), commandErr.Code)
+			assert.Equal(t, "customer has not opted in", commandErr.Description)
+			assert.Equal(t, "el_cst_1", commandErr.CustomerID)
+		}
+	})
+
+	t.Run("It should return failed payment statuses as errors", func(t *testing.T) {
+		party := &elarian.PaymentCounterParty{
+			DebitParty:  &elarian.Wallet{CustomerID: "el_cst_2", WalletID: "wallet"},
+			CreditParty: &elarian.Purse{PurseID: "el_prs_1"},
+		}
+		_, err := service.InitiatePayment(ctx, party, &elarian.Cash{CurrencyCode: "KES", Amount: 100})
+		assert.True(t, errors.Is(err, elarian.ErrPaymentInsufficientFunds))
+		var commandErr *elarian.CommandError
+		if assert.True(t, errors.As(err, &commandErr)) {
+			assert.Equal(t, int32(elarian.PaymentStatusInsufficientFunds), commandErr.Code)
+			assert.Equal(t, "el_cst_2", commandErr.CustomerID)
+		}
+	})
+
+	t.Run("It should return failed consent statuses as errors", func(t *testing.T) {
+		_, err := service.UpdateMessagingConsent(ctx, number, channel, elarian.MessagingConsentUpdateAllow)
+		assert.True(t, errors.Is(err, elarian.ErrMessagingConsentInvalidChannelNumber))
+		assert.False(t, errors.Is(err, elarian.ErrMessageDeliveryInvalidChannelNumber))
+	})
+
+	t.Run("It should return false statuses as errors", func(t *testing.T) {
+		_, err := service.UpdateCustomerTag(ctx, elarian.CustomerID("el_cst_3"), &elarian.Tag{Key: "tier", Value: "gold"})
+		assert.True(t, errors.Is(err, elarian.ErrCommandFailed))
+		var commandErr *elarian.CommandError
+		if assert.True(t, errors.As(err, &commandErr)) {
+			assert.Equal(t, int32(0), commandErr.Code)
+			assert.Equal(t, "customer not found", commandErr.Description)
+			assert.Equal(t, "el_cst_3", commandErr.CustomerID)
+		}
+	})
+
+	t.Run("It should return replies with failed statuses when StatusErrors is not set", func(t *testing.T) {
+		opts, conOpts := server.Options()
+		plain, err := elarian.Connect(opts, conOpts)
+		if err != nil {
+			t.Fatalf("Error %v", err)
+		}
+		defer plain.Disconnect()
+		server.WaitForClient(t)
+		reply, err := plain.SendMessage(ctx, number, channel, elarian.TextMessage("Hello World"))
+		assert.Nil(t, err)
+		assert.Equal(t, elarian.MessageDeliveryStatusNoConsent, reply.Status)
+	})
+
+	t.Run("It should map statuses to errors", func(t *testing.T) {
+		assert.Nil(t, elarian.MessageDeliveryStatusDelivered.Err())
+		assert.Equal(t, elarian.ErrMessageDeliveryFailed, elarian.MessageDeliveryStatus(499).Err())
+		assert.Nil(t, elarian.PaymentStatusPendingConfirmation.Err())
+		assert.Nil(t, elarian.PaymentStatusSuccess.Err())
+		assert.Equal(t, elarian.ErrPaymentReversed, elarian.PaymentStatusReversed.Err())
+		assert.Nil(t, elarian.MessagingConsentStatusCompleted.Err())
+	})
+}
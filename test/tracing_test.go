@@ -0,0 +1,139 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	elarian "github.com/elarianltd/go-sdk"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// mapCarrier is the TextMapCarrier the trace context in rsocket metadata is decoded into
+type mapCarrier map[string]string
+
+func (c mapCarrier) Get(key string) string { return c[key] }
+func (c mapCarrier) Set(key, value string) { c[key] = value }
+func (c mapCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// spanNamed returns the first span exported with name
+func spanNamed(spans tracetest.SpanStubs, name string) (tracetest.SpanStub, bool) {
+	for _, span := range spans {
+		if span.Name == name {
+			return span, true
+		}
+	}
+	return tracetest.SpanStub{}, false
+}
+
+// spanAttribute returns the value of an attribute of the span
+func spanAttribute(span tracetest.SpanStub, key attribute.Key) string {
+	for _, kv := range span.Attributes {
+		if kv.Key == key {
+			return kv.Value.Emit()
+		}
+	}
+	return ""
+}
+
+func Test_Tracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	propagator := propagation.TraceContext{}
+
+	server := newStandInServer(t, elarian.TransportTCP)
+	opts, conOpts := server.Options()
+	opts.TracerProvider = provider
+	opts.Propagator = propagator
+	service, err := elarian.Connect(opts, conOpts)
+	if err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	defer service.Disconnect()
+	server.WaitForClient(t)
+	service.InitializeNotificationStream()
+	service.OnReceivedUssdSession(func(ctx context.Context, svc elarian.Elarian, notification *elarian.UssdSessionNotification, appData *elarian.Appdata, customer *elarian.Customer, cb elarian.NotificationCallBack) error {
+		cb(nil, nil)
+		return nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	t.Run("It should send commands in a span propagated to elarian", func(t *testing.T) {
+		parentCtx, parent := provider.Tracer("test").Start(ctx, "parent")
+		if _, err := service.GetCustomerState(parentCtx, elarian.CustomerID("el_cst_1")); err != nil {
+			t.Fatalf("Error %v", err)
+		}
+		parent.End()
+
+		span, ok := spanNamed(exporter.GetSpans(), "elarian command get_customer_state")
+		if !ok {
+			t.Fatal("no command span was exported")
+		}
+		assert.Equal(t, trace.SpanKindClient, span.SpanKind)
+		assert.Equal(t, parent.SpanContext().SpanID(), span.Parent.SpanID())
+		assert.Equal(t, "get_customer_state", spanAttribute(span, "elarian.command"))
+		assert.Equal(t, "el_cst_1", spanAttribute(span, "elarian.customer_id"))
+
+		metadata := server.CommandMetadata()
+		carrier := mapCarrier{}
+		if err := json.Unmarshal(metadata[len(metadata)-1], &carrier); err != nil {
+			t.Fatalf("Error %v", err)
+		}
+		propagated := trace.SpanContextFromContext(propagator.Extract(context.Background(), carrier))
+		assert.Equal(t, span.SpanContext.SpanID(), propagated.SpanID())
+	})
+
+	t.Run("It should handle notifications in a span that continues elarian's trace and links to the reply", func(t *testing.T) {
+		exporter.Reset()
+		_, remote := provider.Tracer("test").Start(ctx, "elarian")
+		remote.End()
+		carrier := mapCarrier{}
+		propagator.Inject(trace.ContextWithSpan(ctx, remote), carrier)
+		metadata, _ := json.Marshal(carrier)
+
+		_, replyMetadata, err := server.NotifyWithMetadata(ctx, ussdNotification("el_cst_1", "session_1", "1"), metadata)
+		if err != nil {
+			t.Fatalf("Error %v", err)
+		}
+
+		// the notification span ends once the handlers return, after the reply is sent
+		var notification tracetest.SpanStub
+		assert.Eventually(t, func() bool {
+			var ok bool
+			notification, ok = spanNamed(exporter.GetSpans(), "elarian notification received_ussd_session")
+			return ok
+		}, time.Second*5, time.Millisecond*10)
+		assert.Equal(t, trace.SpanKindConsumer, notification.SpanKind)
+		assert.Equal(t, remote.SpanContext().TraceID(), notification.SpanContext.TraceID())
+		assert.Equal(t, remote.SpanContext().SpanID(), notification.Parent.SpanID())
+		assert.Equal(t, "el_cst_1", spanAttribute(notification, "elarian.customer_id"))
+
+		reply, ok := spanNamed(exporter.GetSpans(), "elarian notification reply")
+		if !ok {
+			t.Fatal("no reply span was exported")
+		}
+		assert.Equal(t, notification.SpanContext.SpanID(), reply.Parent.SpanID())
+		assert.Equal(t, "handler", spanAttribute(reply, "elarian.reply"))
+
+		replyCarrier := mapCarrier{}
+		if err := json.Unmarshal(replyMetadata, &replyCarrier); err != nil {
+			t.Fatalf("Error %v", err)
+		}
+		propagated := trace.SpanContextFromContext(propagator.Extract(context.Background(), replyCarrier))
+		assert.Equal(t, reply.SpanContext.SpanID(), propagated.SpanID())
+	})
+}
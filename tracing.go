@@ -0,0 +1,141 @@
+package elarian
+
+import (
+	"context"
+	"encoding/json"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// metadataCarrier holds the trace context propagated in rsocket metadata, it is encoded as a JSON object of the propagator's fields
+type metadataCarrier map[string]string
+
+const tracerName string = "github.com/elarianltd/go-sdk"
+
+// Span attribute keys
+const (
+	attributeCommand        = attribute.Key("elarian.command")
+	attributeNotification   = attribute.Key("elarian.notification")
+	attributeOrgID          = attribute.Key("elarian.org_id")
+	attributeAppID          = attribute.Key("elarian.app_id")
+	attributeCustomerID     = attribute.Key("elarian.customer_id")
+	attributeCustomerNumber = attribute.Key("elarian.customer_number")
+	attributePurseID        = attribute.Key("elarian.purse_id")
+	attributeReply          = attribute.Key("elarian.reply")
+	attributeAttempt        = attribute.Key("elarian.attempt")
+	attributeError          = attribute.Key("elarian.error")
+)
+
+// Get returns the value of a field
+func (c metadataCarrier) Get(key string) string {
+	return c[key]
+}
+
+// Set sets the value of a field
+func (c metadataCarrier) Set(key, value string) {
+	c[key] = value
+}
+
+// Keys returns the fields in the carrier
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// newTracer returns the tracer spans are started with, it comes from Options.TracerProvider or the global provider when it is nil
+func newTracer(options *Options) trace.Tracer {
+	provider := options.TracerProvider
+	if provider == nil {
+		provider = otel.GetTracerProvider()
+	}
+	return provider.Tracer(tracerName)
+}
+
+// injectMetadata encodes the trace context of ctx as rsocket metadata, it is empty when propagator is nil or ctx holds no trace context
+func injectMetadata(ctx context.Context, propagator propagation.TextMapPropagator) []byte {
+	if propagator == nil {
+		return []byte{}
+	}
+	carrier := make(metadataCarrier)
+	propagator.Inject(ctx, carrier)
+	if len(carrier) == 0 {
+		return []byte{}
+	}
+	metadata, err := json.Marshal(carrier)
+	if err != nil {
+		return []byte{}
+	}
+	return metadata
+}
+
+// extractMetadata returns the trace context encoded in rsocket metadata, it is invalid when propagator is nil or the metadata holds none
+func extractMetadata(metadata []byte, propagator propagation.TextMapPropagator) trace.SpanContext {
+	if propagator == nil || len(metadata) == 0 {
+		return trace.SpanContext{}
+	}
+	carrier := make(metadataCarrier)
+	if err := json.Unmarshal(metadata, &carrier); err != nil {
+		return trace.SpanContext{}
+	}
+	return trace.SpanContextFromContext(propagator.Extract(context.Background(), carrier))
+}
+
+// customerAttributes returns the customer ids and numbers a command is sent for
+func customerAttributes(message protoreflect.Message) []attribute.KeyValue {
+	var attributes []attribute.KeyValue
+	message.Range(func(field protoreflect.FieldDescriptor, value protoreflect.Value) bool {
+		switch {
+		case field.Name() == "customer_id" && field.Kind() == protoreflect.StringKind:
+			attributes = append(attributes, attributeCustomerID.String(value.String()))
+		case field.Name() == "customer_number" && field.Message() != nil && !field.IsList():
+			if number := value.Message().Get(field.Message().Fields().ByName("number")); number.String() != "" {
+				attributes = append(attributes, attributeCustomerNumber.String(number.String()))
+			}
+		case field.Message() != nil && !field.IsList() && !field.IsMap():
+			attributes = append(attributes, customerAttributes(value.Message())...)
+		}
+		return true
+	})
+	return attributes
+}
+
+// endSpan records err on the span, if any, and ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// traceNotification starts the span a notification is handled in, it is a child of the trace context elarian sent with the notification, if any.
+// The span ends once the notification is completed
+func (s *elarian) traceNotification(ctx context.Context, req *notificationRequest, kind Notification, envelope *NotificationEnvelope) context.Context {
+	if req.parent.IsValid() {
+		ctx = trace.ContextWithRemoteSpanContext(ctx, req.parent)
+	}
+	attributes := []attribute.KeyValue{
+		attributeNotification.String(kind.String()),
+		attributeOrgID.String(envelope.OrgID),
+		attributeAppID.String(envelope.AppID),
+	}
+	if envelope.CustomerID != "" {
+		attributes = append(attributes, attributeCustomerID.String(envelope.CustomerID))
+	}
+	if envelope.PurseID != "" {
+		attributes = append(attributes, attributePurseID.String(envelope.PurseID))
+	}
+	ctx, span := s.tracer.Start(ctx, "elarian notification "+kind.String(), trace.WithSpanKind(trace.SpanKindConsumer), trace.WithAttributes(attributes...))
+	req.mu.Lock()
+	req.span, req.tracer = span, s.tracer
+	req.mu.Unlock()
+	return ctx
+}
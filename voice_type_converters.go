@@ -315,23 +315,23 @@ func (s *elarian) voiceCallActions(actions []*hera.VoiceCallAction) VoiceCallAct
 
 func (s *elarian) voiceCallNotification(notf *hera.InboundMessageBody_Voice) *Voice {
 	return &Voice{
-		Direction:    CustomerEventDirection(notf.Voice.Direction),
-		Status:       VoiceCallStatus(notf.Voice.Status),
-		StartedAt:    notf.Voice.StartedAt.AsTime(),
-		HangupCase:   VoiceCallHangupCause(notf.Voice.HangupCause),
-		DtmfDigits:   notf.Voice.DtmfDigits.Value,
-		RecordingURL: notf.Voice.RecordingUrl.Value,
+		Direction:    CustomerEventDirection(notf.Voice.GetDirection()),
+		Status:       VoiceCallStatus(notf.Voice.GetStatus()),
+		StartedAt:    notf.Voice.GetStartedAt().AsTime(),
+		HangupCase:   VoiceCallHangupCause(notf.Voice.GetHangupCause()),
+		DtmfDigits:   notf.Voice.GetDtmfDigits().GetValue(),
+		RecordingURL: notf.Voice.GetRecordingUrl().GetValue(),
 		DailData: &VoiceCallDailInput{
-			DestinationNumber: notf.Voice.DialData.DestinationNumber,
-			StartedAt:         notf.Voice.DialData.StartedAt.AsTime(),
-			Duration:          notf.Voice.DialData.Duration.AsDuration(),
+			DestinationNumber: notf.Voice.GetDialData().GetDestinationNumber(),
+			StartedAt:         notf.Voice.GetDialData().GetStartedAt().AsTime(),
+			Duration:          notf.Voice.GetDialData().GetDuration().AsDuration(),
 		},
 		QueueData: &VoiceCallQueueInput{
-			EnqueuedAt:          notf.Voice.QueueData.EnqueuedAt.AsTime(),
-			DequeuedAt:          notf.Voice.QueueData.DequeuedAt.AsTime(),
-			DequeuedToNumber:    notf.Voice.QueueData.DequeuedToNumber.Value,
-			DequeuedToSessionID: notf.Voice.QueueData.DequeuedToSessionId.Value,
-			QueueDuration:       notf.Voice.QueueData.QueueDuration.AsDuration(),
+			EnqueuedAt:          notf.Voice.GetQueueData().GetEnqueuedAt().AsTime(),
+			DequeuedAt:          notf.Voice.GetQueueData().GetDequeuedAt().AsTime(),
+			DequeuedToNumber:    notf.Voice.GetQueueData().GetDequeuedToNumber().GetValue(),
+			DequeuedToSessionID: notf.Voice.GetQueueData().GetDequeuedToSessionId().GetValue(),
+			QueueDuration:       notf.Voice.GetQueueData().GetQueueDuration().AsDuration(),
 		},
 	}
 }